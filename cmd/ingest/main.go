@@ -0,0 +1,49 @@
+// Command ingest rebuilds the knowledge base index from the docs directory.
+// The running bot reloads the index once the file is replaced.
+//
+//	GPT_API_KEY=... go run ./cmd/ingest -docs config/docs -index /data/knowledge.idx
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/adapters"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	docsPath := flag.String("docs", "config/docs", "directory with the markdown and text files")
+	indexPath := flag.String("index", "data/knowledge.idx", "index file, KNOWLEDGE_INDEX_PATH of the bot")
+	chunkSize := flag.Int("chunk-size", 1500, "maximum chunk size in bytes")
+	flag.Parse()
+
+	// The same provider as the bot, the index is built and searched by the same embedding model
+	cfg := adapters.GPTConfig{
+		Provider:       adapters.GPTProvider(os.Getenv("GPT_PROVIDER")),
+		BaseURL:        os.Getenv("GPT_BASE_URL"),
+		APIKey:         os.Getenv("GPT_API_KEY"),
+		EmbeddingModel: os.Getenv("GPT_EMBEDDING_MODEL"),
+	}
+	embedder, err := adapters.NewEmbedderFromConfig(cfg)
+	if err != nil {
+		slog.Error("Unable to create embedder", slog.Any("error", err))
+		os.Exit(1)
+	}
+	kb, err := adapters.NewKnowledgeBase(embedder, *indexPath, 0)
+	if err != nil {
+		slog.Error("Unable to open knowledge base", slog.Any("error", err))
+		os.Exit(1)
+	}
+	files, chunks, err := kb.Ingest(ctx, *docsPath, *chunkSize)
+	if err != nil {
+		slog.Error("Unable to ingest docs", slog.Any("error", err))
+		os.Exit(1)
+	}
+	slog.Info("Docs ingested", slog.Int("files", files), slog.Int("chunks", chunks), slog.String("index", *indexPath))
+}
@@ -3,10 +3,18 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // time zones of the approval rules
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -24,6 +32,109 @@ var (
 	errorLogChatID         = int64(203335723)      // ERROR_LOG_CHAT_ID
 	auditLogChannelID      = int64(-1002404642431) // AUDIT_LOG_CHANNEL_ID
 	auditLogChannelGroupID = int64(-1002364910005) // AUDIT_LOG_CHANNEL_GROUP_ID
+
+	conversationIdleTimeout = 30 * time.Minute // CONVERSATION_IDLE_TIMEOUT
+
+	gptConfig = adapters.GPTConfig{
+		Provider:           adapters.GPTProviderOpenAI, // GPT_PROVIDER
+		BaseURL:            "",                         // GPT_BASE_URL
+		Model:              "gpt-4o-mini",              // GPT_MODEL
+		Timeout:            2 * time.Minute,            // GPT_TIMEOUT
+		TranscriptionModel: "whisper-1",                // GPT_TRANSCRIPTION_MODEL
+		EmbeddingModel:     "text-embedding-3-small",   // GPT_EMBEDDING_MODEL
+		// USD per million tokens
+		Prices: domain.PriceTable{
+			"gpt-4o-mini": {Prompt: 0.15, Completion: 0.6},
+			"gpt-4o":      {Prompt: 2.5, Completion: 10},
+		},
+	}
+	personas = []domain.Persona{
+		{
+			Name:        "frontend-mentor",
+			Description: "Senior front-end developer helping a junior dev",
+			SystemPrompt: `You are a senior front-end developer who helps the junior dev. 
+Explain all your actions. You can answer in Russian or English. 
+STRICT RULE: You can use only telegram html style formatting.`,
+		},
+		{
+			Name:        "go-reviewer",
+			Description: "Go code reviewer",
+			// Reviews refer to the code discussed earlier, so it is summarized rather than dropped
+			Context: domain.ContextPolicy{
+				Strategy:     domain.ContextStrategySummarize,
+				MaxTokens:    32_000,
+				SummaryModel: "gpt-4o-mini",
+			},
+			SystemPrompt: `You are an experienced Go developer reviewing code. 
+Point out bugs, races and non-idiomatic code first, then suggest improvements with short examples. 
+STRICT RULE: You can use only telegram html style formatting.`,
+		},
+		{
+			Name:        "translator",
+			Description: "Translator between Russian and English",
+			SystemPrompt: `You are a professional translator. 
+Translate Russian text to English and any other text to Russian, keep the formatting and tone. 
+Reply with the translation only.`,
+		},
+	}
+	defaultPersona = "frontend-mentor" // DEFAULT_PERSONA
+
+	// contextPolicy keeps conversations within the context window, personas may override it
+	contextPolicy = domain.ContextPolicy{
+		Strategy:  domain.ContextStrategyTruncate,
+		MaxTokens: 16_000, // CONTEXT_MAX_TOKENS
+	}
+
+	// gptModels are offered to users by /model
+	gptModels = []string{"gpt-4o-mini", "gpt-4o"} // GPT_MODELS
+	// gptVisionModels accept images, the first one answers photo questions if the chosen model doesn't
+	gptVisionModels = []string{"gpt-4o-mini", "gpt-4o"} // GPT_VISION_MODELS
+
+	approvalPolicy = domain.ApprovalPolicy{
+		ReminderInterval: 5 * time.Minute,  // APPROVAL_REMINDER_INTERVAL
+		EscalateAfter:    15 * time.Minute, // APPROVAL_ESCALATE_AFTER
+		EscalationChatID: 0,                // APPROVAL_ESCALATION_CHAT_ID, zero disables escalation
+		Deadline:         30 * time.Minute, // APPROVAL_DEADLINE
+		DeadlineStatus:   domain.RequestStatusRejected,
+	}
+
+	quotaPolicy = domain.QuotaPolicy{
+		UserDaily:        domain.Budget{Tokens: 500_000},
+		UserMonthly:      domain.Budget{Cost: 5},
+		TotalDaily:       domain.Budget{Cost: 10},
+		TotalMonthly:     domain.Budget{Cost: 100},
+		AutoApproveBelow: 0.2,
+		OverQuotaStatus:  domain.RequestStatusPending,
+	}
+
+	// moderationPolicy redacts sensitive data in the audit channel and rejects requests leaking credentials
+	moderationPolicy = domain.ModerationPolicy{
+		Block:        []domain.SensitiveKind{domain.SensitiveKindPrivateKey, domain.SensitiveKindAPIKey},
+		CheckContent: true,  // MODERATION_CHECK_CONTENT
+		BlockFlagged: false, // MODERATION_BLOCK_FLAGGED
+	}
+
+	defaultApprovalRulesPath = "config/approval_rules.json" // APPROVAL_RULES_PATH
+
+	// toolURLAllowlist are the hosts the model may fetch pages from, subdomains included
+	toolURLAllowlist = []string{"go.dev", "temporal.io", "core.telegram.org", "developer.mozilla.org"} // TOOL_URL_ALLOWLIST
+	docsPath         = "config/docs"                                                                   // DOCS_PATH
+
+	// knowledgeMinScore filters out the chunks of the docs unrelated to the request
+	knowledgeMinScore = float32(0.3)
+
+	// approverIDs may decide on requests, administrators of the audit channel are used if empty
+	approverIDs = []int64{} // APPROVER_IDS
+
+	// quorumPolicies are dropped until enough approvers are set
+	quorumPolicies = []domain.QuorumPolicy{
+		{
+			Name:      "production",
+			Approvers: []int64{}, // QUORUM_APPROVER_IDS
+			Required:  2,
+			Keywords:  []string{"production", "prod", "database", "databases"},
+		},
+	}
 )
 
 var allowedChatIDs = []int64{
@@ -35,12 +146,39 @@ var allowedChatIDs = []int64{
 }
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	tgToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	gptKey := os.Getenv("GPT_API_KEY")
 	temporalAddr := os.Getenv("TEMPORAL_ADDRESS")
+	usageStoragePath := os.Getenv("USAGE_STORAGE_PATH")
+	quotaStoragePath := os.Getenv("QUOTA_STORAGE_PATH")
+	preferencesStoragePath := os.Getenv("PREFERENCES_STORAGE_PATH")
+	knowledgeIndexPath := os.Getenv("KNOWLEDGE_INDEX_PATH")
+	if knowledgeIndexPath == "" {
+		knowledgeIndexPath = "data/knowledge.idx"
+	}
+	if allowlist := os.Getenv("TOOL_URL_ALLOWLIST"); allowlist != "" {
+		toolURLAllowlist = nil
+		for _, host := range strings.Split(allowlist, ",") {
+			// Hosts are compared with the lowercase host of the URL
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				toolURLAllowlist = append(toolURLAllowlist, host)
+			}
+		}
+	}
+	if path := os.Getenv("DOCS_PATH"); path != "" {
+		docsPath = path
+	}
+	approvalRulesPath := os.Getenv("APPROVAL_RULES_PATH")
+	if approvalRulesPath == "" {
+		approvalRulesPath = defaultApprovalRulesPath
+	}
+	// drainSessions refuses new requests and exits once the running sessions finish,
+	// so that a new workflows.SessionVersion can be deployed
+	drainSessions := os.Getenv("DRAIN_SESSIONS") != ""
+	// callbackSecret signs the approval buttons, changing it invalidates the pending ones
+	callbackSecret := os.Getenv("CALLBACK_SECRET")
 
 	logger := slog.New(slog.NewTextHandler(
 		io.MultiWriter(
@@ -50,6 +188,12 @@ func main() {
 	)
 	slog.SetDefault(logger)
 
+	if callbackSecret == "" {
+		err := errors.New("CALLBACK_SECRET is not set")
+		logger.Error("Invalid callback config", slog.Any("error", err))
+		panic(err)
+	}
+
 	// Set up the Temporal client.
 	temporalClient, err := client.DialContext(ctx, client.Options{
 		HostPort: temporalAddr,
@@ -62,25 +206,172 @@ func main() {
 
 	tgClient := adapters.NewTelegramClient(tgToken)
 
-	gptClient := adapters.NewGPTClient(gptKey)
-	activityTokenStorage := adapters.NewInMemoryTokenStorage()
+	err = loadGPTConfig(&gptConfig)
+	if err != nil {
+		logger.Error("Invalid GPT config", slog.Any("error", err))
+		panic(err)
+	}
+	err = lookupDuration("CONVERSATION_IDLE_TIMEOUT", &conversationIdleTimeout)
+	if err == nil {
+		err = loadChatConfig()
+	}
+	if err != nil {
+		logger.Error("Invalid conversation config", slog.Any("error", err))
+		panic(err)
+	}
+	err = loadApprovalConfig()
+	if err == nil {
+		err = loadModerationConfig()
+	}
+	if err != nil {
+		logger.Error("Invalid approval config", slog.Any("error", err))
+		panic(err)
+	}
+	quorumPolicies = slices.DeleteFunc(quorumPolicies, func(policy domain.QuorumPolicy) bool {
+		if len(policy.Approvers) >= policy.Required {
+			return false
+		}
+		logger.Warn("Quorum policy dropped, not enough approvers", slog.String("policy", policy.Name),
+			slog.Int("approvers", len(policy.Approvers)), slog.Int("required", policy.Required))
+		return true
+	})
+	gptClient, err := adapters.NewGPTClientFromConfig(gptConfig)
+	if err != nil {
+		logger.Error("Unable to create GPT client", slog.Any("error", err))
+		panic(err)
+	}
+	callbackCodec := adapters.NewHMACCallbackCodec([]byte(callbackSecret))
 	markdownHTmlConverter := adapters.NewMarkdownHTMLConverter()
+	quotaStorage, err := adapters.NewQuotaStorage(quotaStoragePath)
+	if err != nil {
+		logger.Error("Unable to open quota storage", slog.Any("error", err))
+		panic(err)
+	}
+	preferencesStorage, err := adapters.NewPreferencesStorage(preferencesStoragePath)
+	if err != nil {
+		logger.Error("Unable to open preferences storage", slog.Any("error", err))
+		panic(err)
+	}
+	usageStorage, err := adapters.NewUsageStorage(usageStoragePath)
+	if err != nil {
+		logger.Error("Unable to open usage storage", slog.Any("error", err))
+		panic(err)
+	}
+	defer func() {
+		if err := usageStorage.Close(); err != nil {
+			logger.Error("Unable to save usage", slog.Any("error", err))
+		}
+	}()
+	go func() {
+		// The usage file is rewritten at most once a minute, Close writes the rest on exit
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := usageStorage.Flush(); err != nil {
+					logger.Error("Unable to save usage", slog.Any("error", err))
+				}
+			}
+		}
+	}()
 
-	act := activities.New(temporalClient, tgClient, gptClient, activityTokenStorage, markdownHTmlConverter)
+	tools := domain.NewToolRegistry(
+		adapters.NewFetchURLTool(toolURLAllowlist, 30*time.Second),
+		adapters.NewCalculatorTool(),
+		adapters.NewDocsLookupTool(docsPath),
+	)
 
-	err = StartWorker(ctx, temporalClient, act)
+	embedder, err := adapters.NewEmbedderFromConfig(gptConfig)
 	if err != nil {
-		logger.Error("start worker", slog.Any("error", err))
+		logger.Error("Unable to create embedder", slog.Any("error", err))
+		panic(err)
+	}
+	knowledge, err := adapters.NewKnowledgeBase(embedder, knowledgeIndexPath, knowledgeMinScore)
+	if err != nil {
+		logger.Error("Unable to open knowledge base", slog.Any("error", err))
+		panic(err)
+	}
+
+	moderator, err := adapters.NewModeratorFromConfig(gptConfig)
+	if err != nil {
+		logger.Error("Unable to create moderator", slog.Any("error", err))
+		panic(err)
+	}
+
+	act := activities.New(temporalClient, tgClient, gptClient, callbackCodec, usageStorage, quotaStorage, tools,
+		knowledge, moderator, markdownHTmlConverter)
+
+	err = workflows.RegisterSearchAttributes(ctx, temporalClient)
+	if err != nil {
+		logger.Error("register search attributes", slog.Any("error", err))
+		panic(err)
+	}
+
+	approvalRules, err := adapters.LoadApprovalRules(approvalRulesPath)
+	if err != nil {
+		logger.Error("Unable to load approval rules", slog.Any("error", err))
 		panic(err)
 	}
 
 	cfg := app.Config{
-		WhitelistedUsers:       allowedChatIDs,
-		AuditLogChannelID:      auditLogChannelID,
-		AuditLogChannelGroupID: auditLogChannelGroupID,
+		WhitelistedUsers:        allowedChatIDs,
+		AuditLogChannelID:       auditLogChannelID,
+		AuditLogChannelGroupID:  auditLogChannelGroupID,
+		ConversationIdleTimeout: conversationIdleTimeout,
+		ApprovalPolicy:          approvalPolicy,
+		QuorumPolicies:          quorumPolicies,
+		Models:                  gptModels,
+		DefaultModel:            gptConfig.Model,
+		VisionModels:            gptVisionModels,
+		Personas:                personas,
+		DefaultPersona:          defaultPersona,
+		ContextPolicy:           contextPolicy,
+		QuotaPolicy:             quotaPolicy,
+		ApprovalRules:           approvalRules,
+		ModerationPolicy:        moderationPolicy,
 	}
 
-	service := app.NewService(tgClient, temporalClient, activityTokenStorage, logger, cfg)
+	var approvers domain.ApproverRegistry = adapters.NewChatAdminsApproverRegistry(tgClient.API, 10*time.Minute)
+	if len(approverIDs) > 0 {
+		approvers = adapters.NewStaticApproverRegistry(approverIDs)
+	}
+
+	speech, err := adapters.NewSpeechToTextFromConfig(gptConfig)
+	if err != nil {
+		logger.Error("Unable to create speech to text", slog.Any("error", err))
+		panic(err)
+	}
+
+	service := app.NewService(tgClient, temporalClient, callbackCodec, approvers,
+		preferencesStorage, usageStorage, quotaStorage, speech,
+		adapters.NewDocumentTextExtractor(), logger, cfg)
+	// Outdated sessions are closed before the worker tries to replay them
+	err = service.RestoreDialogs(ctx)
+	if err != nil {
+		logger.Error("restore dialogs", slog.Any("error", err))
+		panic(err)
+	}
+
+	err = StartWorker(ctx, temporalClient, act)
+	if err != nil {
+		logger.Error("start worker", slog.Any("error", err))
+		panic(err)
+	}
+
+	if drainSessions {
+		go func() {
+			err := service.DrainSessions(ctx, 30*time.Second)
+			if err != nil {
+				logger.Error("drain sessions", slog.Any("error", err))
+				return
+			}
+			logger.Info("Sessions drained, the service can be upgraded")
+			cancel()
+		}()
+	}
 
 	server := ports.NewBotServer(tgToken, allowedChatIDs, logger).Mount(
 		service.PrivateChatRoutes(),
@@ -91,17 +382,180 @@ func main() {
 	<-ctx.Done()
 }
 
+// loadGPTConfig overrides the GPT config and the offered models with the environment.
+func loadGPTConfig(cfg *adapters.GPTConfig) error {
+	cfg.APIKey = os.Getenv("GPT_API_KEY")
+	if provider := os.Getenv("GPT_PROVIDER"); provider != "" {
+		cfg.Provider = adapters.GPTProvider(provider)
+	}
+	if baseURL := os.Getenv("GPT_BASE_URL"); baseURL != "" {
+		cfg.BaseURL = baseURL
+	}
+	if model := os.Getenv("GPT_MODEL"); model != "" {
+		cfg.Model = model
+	}
+	if model := os.Getenv("GPT_TRANSCRIPTION_MODEL"); model != "" {
+		cfg.TranscriptionModel = model
+	}
+	if model := os.Getenv("GPT_EMBEDDING_MODEL"); model != "" {
+		cfg.EmbeddingModel = model
+	}
+	if model := os.Getenv("GPT_MODERATION_MODEL"); model != "" {
+		cfg.ModerationModel = model
+	}
+	if provider := os.Getenv("MODERATION_PROVIDER"); provider != "" {
+		cfg.ModerationProvider = adapters.GPTProvider(provider)
+	}
+	if models := os.Getenv("GPT_MODELS"); models != "" {
+		gptModels = strings.Split(models, ",")
+	}
+	if models := os.Getenv("GPT_VISION_MODELS"); models != "" {
+		gptVisionModels = strings.Split(models, ",")
+	}
+	if timeout := os.Getenv("GPT_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("GPT_TIMEOUT: %w", err)
+		}
+		cfg.Timeout = d
+	}
+	return nil
+}
+
+// loadChatConfig overrides the chat defaults with the environment.
+func loadChatConfig() error {
+	if persona := os.Getenv("DEFAULT_PERSONA"); persona != "" {
+		defaultPersona = strings.TrimSpace(persona)
+	}
+	if _, ok := domain.FindPersona(personas, defaultPersona); defaultPersona != "" && !ok {
+		return fmt.Errorf("DEFAULT_PERSONA: unknown persona %q", defaultPersona)
+	}
+	if value := os.Getenv("CONTEXT_MAX_TOKENS"); value != "" {
+		maxTokens, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("CONTEXT_MAX_TOKENS: %w", err)
+		}
+		if maxTokens < 0 {
+			return fmt.Errorf("CONTEXT_MAX_TOKENS: negative value %d", maxTokens)
+		}
+		contextPolicy.MaxTokens = maxTokens
+	}
+	return nil
+}
+
+// loadModerationConfig overrides the moderation policy with the environment.
+func loadModerationConfig() error {
+	for name, flag := range map[string]*bool{
+		"MODERATION_CHECK_CONTENT": &moderationPolicy.CheckContent,
+		"MODERATION_BLOCK_FLAGGED": &moderationPolicy.BlockFlagged,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*flag = parsed
+	}
+	return nil
+}
+
+// loadApprovalConfig overrides the approval policy with the environment.
+func loadApprovalConfig() error {
+	for name, d := range map[string]*time.Duration{
+		"APPROVAL_REMINDER_INTERVAL": &approvalPolicy.ReminderInterval,
+		"APPROVAL_ESCALATE_AFTER":    &approvalPolicy.EscalateAfter,
+		"APPROVAL_DEADLINE":          &approvalPolicy.Deadline,
+	} {
+		if err := lookupDuration(name, d); err != nil {
+			return err
+		}
+	}
+	if value := os.Getenv("APPROVAL_ESCALATION_CHAT_ID"); value != "" {
+		id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("APPROVAL_ESCALATION_CHAT_ID: %w", err)
+		}
+		approvalPolicy.EscalationChatID = id
+	}
+	if value := os.Getenv("APPROVER_IDS"); value != "" {
+		ids, err := parseIDs(value)
+		if err != nil {
+			return fmt.Errorf("APPROVER_IDS: %w", err)
+		}
+		approverIDs = ids
+	}
+	if value := os.Getenv("QUORUM_APPROVER_IDS"); value != "" {
+		ids, err := parseIDs(value)
+		if err != nil {
+			return fmt.Errorf("QUORUM_APPROVER_IDS: %w", err)
+		}
+		for i := range quorumPolicies {
+			quorumPolicies[i].Approvers = ids
+		}
+	}
+	if approvalPolicy.EscalationChatID != 0 {
+		// Approvers decide on the escalated requests in the escalation chat
+		allowedChatIDs = append(allowedChatIDs, approvalPolicy.EscalationChatID)
+	}
+	return nil
+}
+
+// lookupDuration overrides the duration with the environment variable if it is set.
+func lookupDuration(name string, d *time.Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if parsed < 0 {
+		return fmt.Errorf("%s: negative duration %s", name, value)
+	}
+	*d = parsed
+	return nil
+}
+
+// parseIDs reads the comma separated Telegram IDs.
+func parseIDs(value string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 func StartWorker(ctx context.Context, cli client.Client, a *activities.Activities) error {
 	// Set up the Temporal worker.
 	w := worker.New(cli, domain.ChatRequestsQueue, worker.Options{})
 
 	w.RegisterWorkflow(workflows.ChatGTPSession)
+	w.RegisterWorkflow(workflows.ChatGPTTurn)
 	w.RegisterActivity(a.GetRequestApproval)
-	w.RegisterActivity(a.SendChatGPTRequest)
+	w.RegisterActivity(a.CallTool)
+	w.RegisterActivity(a.SummarizeHistory)
+	w.RegisterActivity(a.RetrieveKnowledge)
+	w.RegisterActivity(a.StreamChatGPTResponse)
 	w.RegisterActivity(a.RejectChatRequest)
 	w.RegisterActivity(a.RespondToUser)
 	w.RegisterActivity(a.ConvertToHTML)
 	w.RegisterActivity(a.CommentRequestWithResponse)
+	w.RegisterActivity(a.RemindApprovers)
+	w.RegisterActivity(a.CloseApprovalRequest)
+	w.RegisterActivity(a.NotifyUser)
+	w.RegisterActivity(a.UpdateApprovalVotes)
+	w.RegisterActivity(a.CompleteApproval)
+	w.RegisterActivity(a.ModerateContent)
+	w.RegisterActivity(a.CheckQuota)
+	w.RegisterActivity(a.PostResolvedRequest)
 
 	err := w.Start()
 	if err != nil {
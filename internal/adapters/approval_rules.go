@@ -0,0 +1,33 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// LoadApprovalRules reads the JSON array of approval rules, a missing file means no rules.
+func LoadApprovalRules(filename string) ([]domain.ApprovalRule, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []domain.ApprovalRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("parse approval rules %s: %w", filename, err)
+	}
+	for _, rule := range rules {
+		err = rule.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,80 @@
+package adapters
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+	"github.com/xenking/managed-tg-gpt-chat/pkg/echotron"
+)
+
+// StaticApproverRegistry allows the configured users to approve requests in any chat.
+type StaticApproverRegistry struct {
+	approvers map[int64]struct{}
+}
+
+var _ domain.ApproverRegistry = (*StaticApproverRegistry)(nil)
+
+func NewStaticApproverRegistry(userIDs []int64) *StaticApproverRegistry {
+	approvers := make(map[int64]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		approvers[id] = struct{}{}
+	}
+	return &StaticApproverRegistry{approvers: approvers}
+}
+
+func (r *StaticApproverRegistry) IsApprover(ctx context.Context, chatID, userID int64) (bool, error) {
+	_, ok := r.approvers[userID]
+	return ok, nil
+}
+
+// ChatAdminsApproverRegistry allows administrators of the chat to approve requests.
+// Administrators are cached for the ttl to not hit Telegram on every callback.
+type ChatAdminsApproverRegistry struct {
+	api    echotron.API
+	ttl    time.Duration
+	admins map[int64]chatAdmins
+	mu     sync.Mutex
+}
+
+type chatAdmins struct {
+	users     map[int64]struct{}
+	expiresAt time.Time
+}
+
+var _ domain.ApproverRegistry = (*ChatAdminsApproverRegistry)(nil)
+
+func NewChatAdminsApproverRegistry(api echotron.API, ttl time.Duration) *ChatAdminsApproverRegistry {
+	return &ChatAdminsApproverRegistry{
+		api:    api,
+		ttl:    ttl,
+		admins: make(map[int64]chatAdmins),
+	}
+}
+
+func (r *ChatAdminsApproverRegistry) IsApprover(ctx context.Context, chatID, userID int64) (bool, error) {
+	r.mu.Lock()
+	admins, ok := r.admins[chatID]
+	r.mu.Unlock()
+	if !ok || time.Now().After(admins.expiresAt) {
+		res, err := r.api.GetChatAdministrators(ctx, chatID)
+		if err != nil {
+			return false, err
+		}
+		admins = chatAdmins{
+			users:     make(map[int64]struct{}, len(res.Result)),
+			expiresAt: time.Now().Add(r.ttl),
+		}
+		for _, member := range res.Result {
+			if member != nil && member.User != nil {
+				admins.users[member.User.ID] = struct{}{}
+			}
+		}
+		r.mu.Lock()
+		r.admins[chatID] = admins
+		r.mu.Unlock()
+	}
+	_, ok = admins.users[userID]
+	return ok, nil
+}
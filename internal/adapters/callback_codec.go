@@ -0,0 +1,130 @@
+package adapters
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+var (
+	ErrInvalidCallback = errors.New("invalid callback data")
+	ErrExpiredCallback = errors.New("expired callback data")
+)
+
+// maxCallbackDataSize is the Telegram limit of the inline keyboard callback data.
+const maxCallbackDataSize = 64
+
+const (
+	callbackFlagRejected = 1 << iota
+	callbackFlagEscalation
+	callbackFlagQuorum
+)
+
+const (
+	callbackHeaderSize    = 1 + 4 // flags, expiration
+	callbackSignatureSize = 10
+)
+
+// HMACCallbackCodec packs the approval reference into a compact binary form
+// signed with HMAC-SHA256: flags | expiration | workflow UUID | workflow ID suffix | signature.
+// Workflow IDs must start with a UUID, e.g. "<uuid>" or "<uuid>-turn-1".
+type HMACCallbackCodec struct {
+	secret []byte
+}
+
+var _ domain.ApprovalCallbackCodec = (*HMACCallbackCodec)(nil)
+
+func NewHMACCallbackCodec(secret []byte) *HMACCallbackCodec {
+	return &HMACCallbackCodec{secret: secret}
+}
+
+func (c *HMACCallbackCodec) Encode(cb domain.ApprovalCallback) (string, error) {
+	if len(cb.WorkflowID) < 36 {
+		return "", fmt.Errorf("workflow ID %q must start with UUID", cb.WorkflowID)
+	}
+	workflowUUID, err := uuid.Parse(cb.WorkflowID[:36])
+	if err != nil {
+		return "", fmt.Errorf("workflow ID %q must start with UUID: %w", cb.WorkflowID, err)
+	}
+
+	var flags byte
+	switch cb.Status {
+	case domain.RequestStatusApproved:
+	case domain.RequestStatusRejected:
+		flags |= callbackFlagRejected
+	default:
+		return "", fmt.Errorf("unexpected callback status %s", cb.Status)
+	}
+	switch cb.ActivityID {
+	case domain.ApprovalActivityID:
+	case domain.EscalationApprovalActivityID:
+		flags |= callbackFlagEscalation
+	default:
+		return "", fmt.Errorf("unexpected callback activity ID %s", cb.ActivityID)
+	}
+	if cb.Quorum {
+		flags |= callbackFlagQuorum
+	}
+
+	payload := make([]byte, callbackHeaderSize, callbackHeaderSize+len(cb.WorkflowID)+callbackSignatureSize)
+	payload[0] = flags
+	binary.BigEndian.PutUint32(payload[1:callbackHeaderSize], uint32(cb.ExpiresAt.Unix()))
+	payload = append(payload, workflowUUID[:]...)
+	payload = append(payload, cb.WorkflowID[36:]...)
+	payload = append(payload, c.sign(payload)...)
+
+	data := base64.RawURLEncoding.EncodeToString(payload)
+	if len(data) > maxCallbackDataSize {
+		return "", fmt.Errorf("callback data for workflow %s exceeds %d bytes", cb.WorkflowID, maxCallbackDataSize)
+	}
+	return data, nil
+}
+
+func (c *HMACCallbackCodec) Decode(data string) (domain.ApprovalCallback, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(raw) < callbackHeaderSize+len(uuid.UUID{})+callbackSignatureSize {
+		return domain.ApprovalCallback{}, ErrInvalidCallback
+	}
+	payload, signature := raw[:len(raw)-callbackSignatureSize], raw[len(raw)-callbackSignatureSize:]
+	if !hmac.Equal(signature, c.sign(payload)) {
+		return domain.ApprovalCallback{}, ErrInvalidCallback
+	}
+
+	flags := payload[0]
+	cb := domain.ApprovalCallback{
+		ActivityID: domain.ApprovalActivityID,
+		Status:     domain.RequestStatusApproved,
+		Quorum:     flags&callbackFlagQuorum != 0,
+		ExpiresAt:  time.Unix(int64(binary.BigEndian.Uint32(payload[1:callbackHeaderSize])), 0),
+	}
+	if flags&callbackFlagRejected != 0 {
+		cb.Status = domain.RequestStatusRejected
+	}
+	if flags&callbackFlagEscalation != 0 {
+		cb.ActivityID = domain.EscalationApprovalActivityID
+	}
+	workflowUUID, err := uuid.FromBytes(payload[callbackHeaderSize : callbackHeaderSize+len(uuid.UUID{})])
+	if err != nil {
+		return domain.ApprovalCallback{}, ErrInvalidCallback
+	}
+	cb.WorkflowID = workflowUUID.String() + string(payload[callbackHeaderSize+len(uuid.UUID{}):])
+
+	if time.Now().After(cb.ExpiresAt) {
+		return cb, ErrExpiredCallback
+	}
+	return cb, nil
+}
+
+func (c *HMACCallbackCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)[:callbackSignatureSize]
+}
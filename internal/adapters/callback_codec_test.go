@@ -0,0 +1,134 @@
+package adapters_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/adapters"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+const testWorkflowID = "0b5d3c1e-6f0a-4d8e-9a61-2f3b4c5d6e7f"
+
+func TestHMACCallbackCodecRoundTrip(t *testing.T) {
+	codec := adapters.NewHMACCallbackCodec([]byte("secret"))
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []domain.ApprovalCallback{
+		{WorkflowID: testWorkflowID, ActivityID: domain.ApprovalActivityID, Status: domain.RequestStatusApproved},
+		{WorkflowID: testWorkflowID + "-turn-1", ActivityID: domain.ApprovalActivityID, Status: domain.RequestStatusRejected},
+		{WorkflowID: testWorkflowID, ActivityID: domain.EscalationApprovalActivityID, Status: domain.RequestStatusApproved, Quorum: true},
+		{WorkflowID: testWorkflowID + "-turn-12345678901", ActivityID: domain.ApprovalActivityID, Status: domain.RequestStatusApproved},
+	}
+	for _, cb := range tests {
+		cb.ExpiresAt = expiresAt
+		data, err := codec.Encode(cb)
+		if err != nil {
+			t.Errorf("Encode(%+v) failed: %v", cb, err)
+			continue
+		}
+		if len(data) > 64 {
+			t.Errorf("Encode(%+v) = %d bytes, expected at most 64", cb, len(data))
+		}
+		decoded, err := codec.Decode(data)
+		if err != nil {
+			t.Errorf("Decode(%q) failed: %v", data, err)
+			continue
+		}
+		if decoded != cb {
+			t.Errorf("Decode(%q) = %+v, expected %+v", data, decoded, cb)
+		}
+	}
+}
+
+func TestHMACCallbackCodecEncodeErrors(t *testing.T) {
+	codec := adapters.NewHMACCallbackCodec([]byte("secret"))
+	valid := domain.ApprovalCallback{
+		WorkflowID: testWorkflowID,
+		ActivityID: domain.ApprovalActivityID,
+		Status:     domain.RequestStatusApproved,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	tests := []struct {
+		name   string
+		modify func(cb *domain.ApprovalCallback)
+	}{
+		{"exceeds 64 bytes", func(cb *domain.ApprovalCallback) { cb.WorkflowID += "-turn-123456789012" }},
+		{"no UUID", func(cb *domain.ApprovalCallback) { cb.WorkflowID = "chat-session" }},
+		{"invalid UUID", func(cb *domain.ApprovalCallback) { cb.WorkflowID = strings.Repeat("x", 36) }},
+		{"unexpected status", func(cb *domain.ApprovalCallback) { cb.Status = domain.RequestStatusPending }},
+		{"unexpected activity", func(cb *domain.ApprovalCallback) { cb.ActivityID = "chat-gpt" }},
+	}
+	for _, tt := range tests {
+		cb := valid
+		tt.modify(&cb)
+		if data, err := codec.Encode(cb); err == nil {
+			t.Errorf("%s: Encode = %q, expected error", tt.name, data)
+		}
+	}
+}
+
+func TestHMACCallbackCodecDecodeErrors(t *testing.T) {
+	codec := adapters.NewHMACCallbackCodec([]byte("secret"))
+	cb := domain.ApprovalCallback{
+		WorkflowID: testWorkflowID,
+		ActivityID: domain.ApprovalActivityID,
+		Status:     domain.RequestStatusRejected,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	valid, err := codec.Encode(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := adapters.NewHMACCallbackCodec([]byte("forged")).Encode(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip the rejected flag, keeping the signature
+	flipped := []byte(valid)
+	flipped[0] ^= 'B' ^ 'A'
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not base64", "approve:" + testWorkflowID},
+		{"too short", valid[:20]},
+		{"signed with another secret", forged},
+		{"tampered payload", string(flipped)},
+		{"truncated signature", valid[:len(valid)-1]},
+	}
+	for _, tt := range tests {
+		decoded, err := codec.Decode(tt.data)
+		if !errors.Is(err, adapters.ErrInvalidCallback) {
+			t.Errorf("%s: Decode error = %v, expected %v", tt.name, err, adapters.ErrInvalidCallback)
+		}
+		if decoded.WorkflowID != "" {
+			t.Errorf("%s: Decode = %+v, expected empty callback", tt.name, decoded)
+		}
+	}
+}
+
+func TestHMACCallbackCodecExpired(t *testing.T) {
+	codec := adapters.NewHMACCallbackCodec([]byte("secret"))
+	cb := domain.ApprovalCallback{
+		WorkflowID: testWorkflowID,
+		ActivityID: domain.ApprovalActivityID,
+		Status:     domain.RequestStatusApproved,
+		ExpiresAt:  time.Now().Add(-time.Minute).Truncate(time.Second),
+	}
+	data, err := codec.Encode(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.Decode(data)
+	if !errors.Is(err, adapters.ErrExpiredCallback) {
+		t.Errorf("Decode error = %v, expected %v", err, adapters.ErrExpiredCallback)
+	}
+	// The expired callback still identifies the request
+	if decoded != cb {
+		t.Errorf("Decode = %+v, expected %+v", decoded, cb)
+	}
+}
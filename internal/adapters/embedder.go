@@ -0,0 +1,108 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// NewEmbedderFromConfig returns domain.Embedder of the configured provider.
+func NewEmbedderFromConfig(cfg GPTConfig) (domain.Embedder, error) {
+	switch cfg.Provider {
+	case GPTProviderOpenAI, GPTProviderOpenAICompatible, "":
+		return NewOpenAIEmbedder(cfg), nil
+	case GPTProviderFake:
+		return NewFakeEmbedder(), nil
+	}
+	return nil, fmt.Errorf("unknown GPT provider %q", cfg.Provider)
+}
+
+type OpenAIEmbedder struct {
+	*openai.Client
+	model string
+}
+
+func NewOpenAIEmbedder(cfg GPTConfig) *OpenAIEmbedder {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	model := cfg.EmbeddingModel
+	if model == "" {
+		model = string(openai.SmallEmbedding3)
+	}
+	return &OpenAIEmbedder{
+		Client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := e.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.model),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(resp.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		vectors[data.Index] = data.Embedding
+	}
+	return vectors, nil
+}
+
+// fakeEmbeddingSize is the number of the hashed word buckets of FakeEmbedder.
+const fakeEmbeddingSize = 256
+
+// FakeEmbedder hashes the words of the text into the vector without calling any API,
+// texts sharing words are similar, for tests and local runs.
+type FakeEmbedder struct{}
+
+func NewFakeEmbedder() *FakeEmbedder {
+	return &FakeEmbedder{}
+}
+
+func (e *FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vector := make([]float32, fakeEmbeddingSize)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(word))
+			vector[h.Sum32()%fakeEmbeddingSize]++
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+// normalize scales the vector to the unit length, so that the dot product is the cosine similarity.
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = v * scale
+	}
+	return normalized
+}
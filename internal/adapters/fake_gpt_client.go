@@ -0,0 +1,61 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// FakeGPTClient answers deterministically without calling any LLM, for tests and local runs.
+type FakeGPTClient struct{}
+
+func NewFakeGPTClient() *FakeGPTClient {
+	return &FakeGPTClient{}
+}
+
+func (c *FakeGPTClient) Ask(ctx context.Context, opts domain.ChatOptions, msgs ...domain.ChatMessage) (*domain.ChatAnswer, error) {
+	return c.AskStream(ctx, opts, func(string) {}, msgs...)
+}
+
+// AskStream echoes the last user message word by word, images are counted.
+func (c *FakeGPTClient) AskStream(ctx context.Context, opts domain.ChatOptions, onChunk func(content string), msgs ...domain.ChatMessage) (*domain.ChatAnswer, error) {
+	var request domain.ChatMessage
+	promptTokens := 0
+	for _, msg := range msgs {
+		promptTokens += len(strings.Fields(msg.Content))
+		for _, attachment := range msg.Attachments {
+			promptTokens += len(strings.Fields(attachment.Text))
+		}
+		if msg.Role == domain.ChatMessageRoleUser {
+			request = msg
+		}
+	}
+
+	words := append([]string{"Echo:"}, strings.Fields(request.Content)...)
+	if len(request.Images) > 0 {
+		words = append(words, fmt.Sprintf("[%d image(s)]", len(request.Images)))
+	}
+	var content strings.Builder
+	for i, word := range words {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			content.WriteByte(' ')
+		}
+		content.WriteString(word)
+		onChunk(content.String())
+	}
+
+	return &domain.ChatAnswer{
+		Request:  msgs,
+		Response: []domain.ChatMessage{{Role: domain.ChatMessageRoleAssistant, Content: content.String()}},
+		Usage: domain.TokenUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: len(words),
+			TotalTokens:      promptTokens + len(words),
+		},
+	}, nil
+}
@@ -2,56 +2,318 @@ package adapters
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 
 	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
 )
 
-type GPTClient struct {
-	*openai.Client
+type GPTProvider string
+
+const (
+	GPTProviderOpenAI           GPTProvider = "openai"
+	GPTProviderOpenAICompatible GPTProvider = "openai-compatible"
+	GPTProviderFake             GPTProvider = "fake"
+	// GPTProviderNone disables the optional services, e.g. the moderation.
+	GPTProviderNone GPTProvider = "none"
+)
+
+// GPTConfig selects the LLM provider, BaseURL points to the OpenAI-compatible endpoint,
+// e.g. http://localhost:11434/v1 of Ollama.
+type GPTConfig struct {
+	Provider GPTProvider
+	BaseURL  string
+	APIKey   string
+	Model    string
+	Timeout  time.Duration
+	// Prices estimate the cost of the answers.
+	Prices domain.PriceTable
+	// TranscriptionModel transcribes voice messages.
+	TranscriptionModel string
+	// EmbeddingModel embeds the docs of the knowledge base.
+	EmbeddingModel string
+	// ModerationModel screens the requests, the default model of the endpoint if empty.
+	ModerationModel string
+	// ModerationProvider screens the requests, the Provider if empty.
+	// OpenAI-compatible endpoints rarely serve the moderations API, they aren't used
+	// unless the provider is set to GPTProviderOpenAI. GPTProviderNone disables the moderation.
+	ModerationProvider GPTProvider
 }
 
-func NewGPTClient(token string) *GPTClient {
-	return &GPTClient{
-		Client: openai.NewClient(token),
+// NewGPTClientFromConfig returns domain.GPTClient of the configured provider.
+func NewGPTClientFromConfig(cfg GPTConfig) (domain.GPTClient, error) {
+	switch cfg.Provider {
+	case GPTProviderOpenAI, "":
+		return NewGPTClient(cfg), nil
+	case GPTProviderOpenAICompatible:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("base URL is required for %s provider", cfg.Provider)
+		}
+		return NewGPTClient(cfg), nil
+	case GPTProviderFake:
+		return NewFakeGPTClient(), nil
 	}
+	return nil, fmt.Errorf("unknown GPT provider %q", cfg.Provider)
 }
 
-var systemPrompt = openai.ChatCompletionMessage{
-	Role: openai.ChatMessageRoleSystem,
-	Content: `You are a senior front-end developer who helps the junior dev. 
-Explain all your actions. You can answer in Russian or English. 
-STRICT RULE: You can use only telegram html style formatting.`,
+type GPTClient struct {
+	*openai.Client
+	model   string
+	timeout time.Duration
+	prices  domain.PriceTable
 }
 
-func (c *GPTClient) Ask(ctx context.Context, msgs ...domain.ChatMessage) (*domain.ChatAnswer, error) {
-	requestMessages := []openai.ChatCompletionMessage{systemPrompt}
-	for _, msg := range msgs {
-		requestMessages = append(requestMessages, openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
+func NewGPTClient(cfg GPTConfig) *GPTClient {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	clientConfig.HTTPClient = &http.Client{
+		Transport: retryAfterTransport{http.DefaultTransport},
 	}
+	model := cfg.Model
+	if model == "" {
+		model = openai.GPT4oMini
+	}
+	return &GPTClient{
+		Client:  openai.NewClientWithConfig(clientConfig),
+		model:   model,
+		timeout: cfg.Timeout,
+		prices:  cfg.Prices,
+	}
+}
+
+func (c *GPTClient) Ask(ctx context.Context, opts domain.ChatOptions, msgs ...domain.ChatMessage) (*domain.ChatAnswer, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, retryAfter := withRetryAfter(ctx)
+	model := c.chooseModel(opts)
 	resp, err := c.CreateChatCompletion(ctx,
 		openai.ChatCompletionRequest{
-			Model:    openai.GPT4oMini,
-			Messages: requestMessages,
+			Model:    model,
+			Messages: makeRequestMessages(opts, msgs),
+			Tools:    makeTools(opts),
 		},
 	)
 	if err != nil {
-		return nil, err
+		return nil, classifyGPTError(err, *retryAfter)
 	}
 	var response []domain.ChatMessage
 	for _, msg := range resp.Choices {
+		var toolCalls []domain.ToolCall
+		for _, call := range msg.Message.ToolCalls {
+			toolCalls = append(toolCalls, domain.ToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
 		response = append(response, domain.ChatMessage{
-			Role:    msg.Message.Role,
-			Content: msg.Message.Content,
+			Role:      msg.Message.Role,
+			Content:   msg.Message.Content,
+			ToolCalls: toolCalls,
 		})
 	}
 
 	return &domain.ChatAnswer{
 		Request:  msgs,
 		Response: response,
+		Usage:    c.tokenUsage(model, resp.Usage),
+	}, nil
+}
+
+func (c *GPTClient) AskStream(ctx context.Context, opts domain.ChatOptions, onChunk func(content string), msgs ...domain.ChatMessage) (*domain.ChatAnswer, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, retryAfter := withRetryAfter(ctx)
+	model := c.chooseModel(opts)
+	stream, err := c.CreateChatCompletionStream(ctx,
+		openai.ChatCompletionRequest{
+			Model:         model,
+			Messages:      makeRequestMessages(opts, msgs),
+			Tools:         makeTools(opts),
+			Stream:        true,
+			StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+		},
+	)
+	if err != nil {
+		return nil, classifyGPTError(err, *retryAfter)
+	}
+	defer stream.Close()
+
+	var (
+		content   strings.Builder
+		role      = openai.ChatMessageRoleAssistant
+		usage     domain.TokenUsage
+		toolCalls []domain.ToolCall
+	)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, classifyGPTError(err, *retryAfter)
+		}
+		if chunk.Usage != nil {
+			usage = c.tokenUsage(model, *chunk.Usage)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Role != "" {
+			role = delta.Role
+		}
+		for _, call := range delta.ToolCalls {
+			toolCalls = appendToolCallDelta(toolCalls, call)
+		}
+		if delta.Content == "" {
+			continue
+		}
+		content.WriteString(delta.Content)
+		onChunk(content.String())
+	}
+
+	return &domain.ChatAnswer{
+		Request:  msgs,
+		Response: []domain.ChatMessage{{Role: role, Content: content.String(), ToolCalls: toolCalls}},
+		Usage:    usage,
 	}, nil
 }
+
+// appendToolCallDelta accumulates the streamed tool calls, the deltas of a call share its index.
+func appendToolCallDelta(calls []domain.ToolCall, delta openai.ToolCall) []domain.ToolCall {
+	index := len(calls) - 1
+	if delta.Index != nil {
+		index = *delta.Index
+	} else if delta.ID != "" || index < 0 {
+		index = len(calls)
+	}
+	for len(calls) <= index {
+		calls = append(calls, domain.ToolCall{})
+	}
+	if delta.ID != "" {
+		calls[index].ID = delta.ID
+	}
+	calls[index].Name += delta.Function.Name
+	calls[index].Arguments += delta.Function.Arguments
+	return calls
+}
+
+func (c *GPTClient) chooseModel(opts domain.ChatOptions) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return c.model
+}
+
+func (c *GPTClient) tokenUsage(model string, u openai.Usage) domain.TokenUsage {
+	usage := domain.TokenUsage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	usage.Cost = c.prices.Cost(model, usage)
+	return usage
+}
+
+func (c *GPTClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func makeRequestMessages(opts domain.ChatOptions, msgs []domain.ChatMessage) []openai.ChatCompletionMessage {
+	var requestMessages []openai.ChatCompletionMessage
+	if opts.SystemPrompt != "" {
+		requestMessages = append(requestMessages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: opts.SystemPrompt,
+		})
+	}
+	for _, msg := range msgs {
+		if len(msg.Knowledge) > 0 {
+			requestMessages = append(requestMessages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: domain.KnowledgeContext(msg.Knowledge),
+			})
+		}
+		for _, attachment := range msg.Attachments {
+			requestMessages = append(requestMessages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: attachment.Context(),
+			})
+		}
+		if len(msg.Images) == 0 {
+			requestMessages = append(requestMessages, openai.ChatCompletionMessage{
+				Role:       msg.Role,
+				Content:    msg.Content,
+				ToolCalls:  makeToolCalls(msg.ToolCalls),
+				ToolCallID: msg.ToolCallID,
+			})
+			continue
+		}
+		var parts []openai.ChatMessagePart
+		if msg.Content != "" {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeText,
+				Text: msg.Content,
+			})
+		}
+		for _, image := range msg.Images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL:    image.URL,
+					Detail: openai.ImageURLDetailAuto,
+				},
+			})
+		}
+		requestMessages = append(requestMessages, openai.ChatCompletionMessage{
+			Role:         msg.Role,
+			MultiContent: parts,
+		})
+	}
+	return requestMessages
+}
+
+func makeToolCalls(calls []domain.ToolCall) []openai.ToolCall {
+	var toolCalls []openai.ToolCall
+	for _, call := range calls {
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:   call.ID,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		})
+	}
+	return toolCalls
+}
+
+func makeTools(opts domain.ChatOptions) []openai.Tool {
+	if opts.NoTools {
+		return nil
+	}
+	var tools []openai.Tool
+	for _, definition := range opts.Tools {
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        definition.Name,
+				Description: definition.Description,
+				Parameters:  definition.Parameters,
+			},
+		})
+	}
+	return tools
+}
@@ -0,0 +1,102 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// retryAfterKey keeps the *time.Duration the retryAfterTransport stores the Retry-After header into.
+type retryAfterKey struct{}
+
+// withRetryAfter lets the retryAfterTransport report the delay asked by the provider,
+// go-openai drops the headers of the failed responses.
+func withRetryAfter(ctx context.Context) (context.Context, *time.Duration) {
+	retryAfter := new(time.Duration)
+	return context.WithValue(ctx, retryAfterKey{}, retryAfter), retryAfter
+}
+
+type retryAfterTransport struct {
+	http.RoundTripper
+}
+
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	if retryAfter, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok {
+		*retryAfter = parseRetryAfter(resp.Header, time.Now())
+	}
+	return resp, nil
+}
+
+// parseRetryAfter reads the delay in milliseconds of retry-after-ms sent by OpenAI
+// or the standard Retry-After in seconds or as the date.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// classifyGPTError wraps the errors reported by the provider into domain.GPTError,
+// network errors and timeouts are returned as is.
+func classifyGPTError(err error, retryAfter time.Duration) error {
+	var (
+		apiErr *openai.APIError
+		reqErr *openai.RequestError
+		kind   domain.GPTErrorKind
+	)
+	switch {
+	case errors.As(err, &apiErr):
+		kind = classifyAPIError(apiErr.HTTPStatusCode, fmt.Sprint(apiErr.Code), apiErr.Type)
+	case errors.As(err, &reqErr):
+		kind = classifyAPIError(reqErr.HTTPStatusCode, "", "")
+	}
+	if kind == "" {
+		return err
+	}
+	return &domain.GPTError{
+		Kind:       kind,
+		RetryAfter: retryAfter,
+		Err:        err,
+	}
+}
+
+func classifyAPIError(status int, code, errType string) domain.GPTErrorKind {
+	switch {
+	case code == "context_length_exceeded" || code == "string_above_max_length":
+		return domain.GPTErrorContextLength
+	case code == "insufficient_quota" || errType == "insufficient_quota":
+		return domain.GPTErrorQuotaExceeded
+	case code == "content_filter" || code == "content_policy_violation":
+		return domain.GPTErrorContentFiltered
+	case code == "invalid_api_key" || status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return domain.GPTErrorUnauthorized
+	case status == http.StatusTooManyRequests:
+		return domain.GPTErrorRateLimited
+	case status == http.StatusRequestTimeout || status == http.StatusConflict || status >= http.StatusInternalServerError:
+		return domain.GPTErrorUnavailable
+	case status >= http.StatusBadRequest:
+		return domain.GPTErrorInvalidRequest
+	case strings.Contains(code, "rate_limit"):
+		return domain.GPTErrorRateLimited
+	}
+	return ""
+}
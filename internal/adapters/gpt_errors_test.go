@@ -0,0 +1,102 @@
+package adapters
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestClassifyAPIError(t *testing.T) {
+	tests := []struct {
+		status  int
+		code    string
+		errType string
+		kind    domain.GPTErrorKind
+	}{
+		{http.StatusBadRequest, "context_length_exceeded", "invalid_request_error", domain.GPTErrorContextLength},
+		{http.StatusBadRequest, "string_above_max_length", "invalid_request_error", domain.GPTErrorContextLength},
+		{http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", domain.GPTErrorQuotaExceeded},
+		{http.StatusTooManyRequests, "", "insufficient_quota", domain.GPTErrorQuotaExceeded},
+		{http.StatusBadRequest, "content_filter", "", domain.GPTErrorContentFiltered},
+		{http.StatusBadRequest, "content_policy_violation", "invalid_request_error", domain.GPTErrorContentFiltered},
+		{http.StatusUnauthorized, "invalid_api_key", "invalid_request_error", domain.GPTErrorUnauthorized},
+		{http.StatusUnauthorized, "", "", domain.GPTErrorUnauthorized},
+		{http.StatusForbidden, "", "", domain.GPTErrorUnauthorized},
+		{http.StatusTooManyRequests, "rate_limit_exceeded", "requests", domain.GPTErrorRateLimited},
+		{http.StatusTooManyRequests, "", "", domain.GPTErrorRateLimited},
+		{http.StatusRequestTimeout, "", "", domain.GPTErrorUnavailable},
+		{http.StatusConflict, "", "", domain.GPTErrorUnavailable},
+		{http.StatusInternalServerError, "", "server_error", domain.GPTErrorUnavailable},
+		{http.StatusBadGateway, "", "", domain.GPTErrorUnavailable},
+		{http.StatusServiceUnavailable, "", "", domain.GPTErrorUnavailable},
+		{http.StatusBadRequest, "model_not_found", "invalid_request_error", domain.GPTErrorInvalidRequest},
+		{http.StatusNotFound, "", "", domain.GPTErrorInvalidRequest},
+		{http.StatusUnprocessableEntity, "", "", domain.GPTErrorInvalidRequest},
+		// Errors of the stream come without the status
+		{0, "rate_limit_exceeded", "", domain.GPTErrorRateLimited},
+		{0, "context_length_exceeded", "", domain.GPTErrorContextLength},
+		{0, "", "", ""},
+		{http.StatusOK, "", "", ""},
+	}
+	for _, tt := range tests {
+		kind := classifyAPIError(tt.status, tt.code, tt.errType)
+		if kind != tt.kind {
+			t.Errorf("classifyAPIError(%d, %q, %q) = %q, expected %q", tt.status, tt.code, tt.errType, kind, tt.kind)
+		}
+	}
+}
+
+func TestClassifyGPTError(t *testing.T) {
+	network := errors.New("connection reset by peer")
+	tests := []struct {
+		name string
+		err  error
+		kind domain.GPTErrorKind
+	}{
+		{"API error", &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Code: "rate_limit_exceeded"}, domain.GPTErrorRateLimited},
+		{"API error without code", &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}, domain.GPTErrorUnavailable},
+		{"request error", &openai.RequestError{HTTPStatusCode: http.StatusBadGateway, Err: network}, domain.GPTErrorUnavailable},
+		{"network error", network, ""},
+	}
+	for _, tt := range tests {
+		err := classifyGPTError(tt.err, time.Second)
+		var gptErr *domain.GPTError
+		if !errors.As(err, &gptErr) {
+			if tt.kind != "" || err != tt.err {
+				t.Errorf("%s: classifyGPTError = %v, expected %q", tt.name, err, tt.kind)
+			}
+			continue
+		}
+		if gptErr.Kind != tt.kind || gptErr.RetryAfter != time.Second || !errors.Is(err, tt.err) {
+			t.Errorf("%s: classifyGPTError = %+v, expected %q", tt.name, gptErr, tt.kind)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"1500"}}, 1500 * time.Millisecond},
+		{"milliseconds first", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"3"}}, 250 * time.Millisecond},
+		{"seconds", http.Header{"Retry-After": {"20"}}, 20 * time.Second},
+		{"date", http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute},
+		{"past date", http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0},
+		{"negative", http.Header{"Retry-After": {"-5"}}, 0},
+		{"garbage", http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		if retryAfter := parseRetryAfter(tt.header, now); retryAfter != tt.expected {
+			t.Errorf("%s: parseRetryAfter = %s, expected %s", tt.name, retryAfter, tt.expected)
+		}
+	}
+}
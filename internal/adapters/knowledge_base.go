@@ -0,0 +1,176 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// embedBatchSize is the number of chunks embedded by a single request.
+const embedBatchSize = 64
+
+type knowledgeEntry struct {
+	Source string
+	Text   string
+	Vector []float32
+}
+
+// KnowledgeBase is the vector index of the docs kept in a single file.
+// The index is searched in memory and reloaded when the file is rebuilt by the ingestion.
+type KnowledgeBase struct {
+	embedder domain.Embedder
+	filename string
+	minScore float32
+
+	mu      sync.RWMutex
+	entries []knowledgeEntry
+	modTime time.Time
+}
+
+// NewKnowledgeBase opens the index, a missing file is an empty index.
+// Chunks less similar to the query than minScore are not returned.
+func NewKnowledgeBase(embedder domain.Embedder, filename string, minScore float32) (*KnowledgeBase, error) {
+	kb := &KnowledgeBase{
+		embedder: embedder,
+		filename: filename,
+		minScore: minScore,
+	}
+	err := kb.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+	return kb, nil
+}
+
+func (kb *KnowledgeBase) Search(ctx context.Context, query string, k int) ([]domain.KnowledgeChunk, error) {
+	err := kb.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	if len(kb.entries) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := kb.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	queryVector := normalize(vectors[0])
+	var chunks []domain.KnowledgeChunk
+	for _, entry := range kb.entries {
+		score := dot(queryVector, entry.Vector)
+		if score >= kb.minScore {
+			chunks = append(chunks, domain.KnowledgeChunk{Source: entry.Source, Text: entry.Text, Score: score})
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].Score > chunks[j].Score })
+	return chunks[:min(len(chunks), k)], nil
+}
+
+// Ingest rebuilds the index from the markdown and text files of the directory.
+// Sources are the paths relative to the directory.
+func (kb *KnowledgeBase) Ingest(ctx context.Context, dir string, chunkSize int) (files, chunks int, err error) {
+	var entries []knowledgeEntry
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext != ".md" && ext != ".txt" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		source, _ := filepath.Rel(dir, path)
+		for _, text := range domain.ChunkText(string(data), chunkSize) {
+			entries = append(entries, knowledgeEntry{Source: filepath.ToSlash(source), Text: text})
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for start := 0; start < len(entries); start += embedBatchSize {
+		batch := entries[start:min(start+embedBatchSize, len(entries))]
+		texts := make([]string, len(batch))
+		for i, entry := range batch {
+			texts[i] = entry.Text
+		}
+		vectors, err := kb.embedder.Embed(ctx, texts)
+		if err != nil {
+			return 0, 0, fmt.Errorf("embed chunks of %s: %w", batch[0].Source, err)
+		}
+		for i := range batch {
+			batch[i].Vector = normalize(vectors[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(entries)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = os.MkdirAll(filepath.Dir(kb.filename), 0o755)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = writeFileAtomic(kb.filename, buf.Bytes())
+	if err != nil {
+		return 0, 0, err
+	}
+	return files, len(entries), kb.reloadIfChanged()
+}
+
+func (kb *KnowledgeBase) reloadIfChanged() error {
+	info, err := os.Stat(kb.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	kb.mu.RLock()
+	changed := !info.ModTime().Equal(kb.modTime)
+	kb.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	data, err := os.ReadFile(kb.filename)
+	if err != nil {
+		return err
+	}
+	var entries []knowledgeEntry
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&entries)
+	if err != nil {
+		return fmt.Errorf("decode knowledge index %s: %w", kb.filename, err)
+	}
+	kb.mu.Lock()
+	kb.entries = entries
+	kb.modTime = info.ModTime()
+	kb.mu.Unlock()
+	return nil
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range min(len(a), len(b)) {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,99 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// NewModeratorFromConfig returns domain.Moderator of the configured moderation provider,
+// it uses the same endpoint and credentials as the chat completions.
+// Nil is returned if the moderation is disabled, requests are then only redacted.
+func NewModeratorFromConfig(cfg GPTConfig) (domain.Moderator, error) {
+	provider := cfg.ModerationProvider
+	if provider == "" {
+		provider = cfg.Provider
+	}
+	switch provider {
+	case GPTProviderOpenAI, "":
+		return NewOpenAIModerator(cfg), nil
+	case GPTProviderOpenAICompatible, GPTProviderNone:
+		return nil, nil
+	case GPTProviderFake:
+		return NewFakeModerator(), nil
+	}
+	return nil, fmt.Errorf("unknown moderation provider %q", provider)
+}
+
+// OpenAIModerator classifies content by the OpenAI moderations API.
+type OpenAIModerator struct {
+	*openai.Client
+	model string
+}
+
+// NewOpenAIModerator uses the default model of the endpoint if the ModerationModel is not set.
+func NewOpenAIModerator(cfg GPTConfig) *OpenAIModerator {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	return &OpenAIModerator{
+		Client: openai.NewClientWithConfig(clientConfig),
+		model:  cfg.ModerationModel,
+	}
+}
+
+func (m *OpenAIModerator) Moderate(ctx context.Context, text string) ([]string, error) {
+	resp, err := m.Moderations(ctx, openai.ModerationRequest{
+		Input: text,
+		Model: m.model,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var categories []string
+	for _, result := range resp.Results {
+		if !result.Flagged {
+			continue
+		}
+		// The categories are the boolean fields named by the JSON tags
+		data, err := json.Marshal(result.Categories)
+		if err != nil {
+			return nil, err
+		}
+		var flags map[string]bool
+		if err = json.Unmarshal(data, &flags); err != nil {
+			return nil, err
+		}
+		for category, flagged := range flags {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
+
+// FakeModerator flags the requests asking for it, for tests and local runs.
+type FakeModerator struct{}
+
+func NewFakeModerator() *FakeModerator {
+	return &FakeModerator{}
+}
+
+func (m *FakeModerator) Moderate(ctx context.Context, text string) ([]string, error) {
+	if strings.Contains(strings.ToLower(text), "flag me") {
+		return []string{"test"}, nil
+	}
+	return nil, nil
+}
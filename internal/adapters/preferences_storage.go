@@ -0,0 +1,66 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// PreferencesStorage keeps the preferences chosen by users and the chat defaults set by admins.
+// With the filename the preferences are kept in a JSON file to survive restarts.
+type PreferencesStorage struct {
+	filename string
+	prefs    map[int64]domain.UserPreferences
+	mu       sync.RWMutex
+}
+
+var _ domain.PreferencesStorage = (*PreferencesStorage)(nil)
+
+// NewPreferencesStorage loads the preferences from the file, empty filename keeps them in memory only.
+func NewPreferencesStorage(filename string) (*PreferencesStorage, error) {
+	s := &PreferencesStorage{
+		filename: filename,
+		prefs:    make(map[int64]domain.UserPreferences),
+	}
+	if filename == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &s.prefs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *PreferencesStorage) Get(chatID int64) (domain.UserPreferences, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefs, ok := s.prefs[chatID]
+	return prefs, ok
+}
+
+func (s *PreferencesStorage) Set(chatID int64, prefs domain.UserPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefs[chatID] = prefs
+	if s.filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.prefs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename, data)
+}
@@ -0,0 +1,66 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// QuotaStorage keeps the user quotas set by admins.
+// With the filename the quotas are kept in a JSON file to survive restarts.
+type QuotaStorage struct {
+	filename string
+	quotas   map[int64]domain.UserQuota
+	mu       sync.RWMutex
+}
+
+var _ domain.QuotaStorage = (*QuotaStorage)(nil)
+
+// NewQuotaStorage loads the quotas from the file, empty filename keeps them in memory only.
+func NewQuotaStorage(filename string) (*QuotaStorage, error) {
+	s := &QuotaStorage{
+		filename: filename,
+		quotas:   make(map[int64]domain.UserQuota),
+	}
+	if filename == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &s.quotas)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *QuotaStorage) Get(chatID int64) (domain.UserQuota, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	quota, ok := s.quotas[chatID]
+	return quota, ok
+}
+
+func (s *QuotaStorage) Set(chatID int64, quota domain.UserQuota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quotas[chatID] = quota
+	if s.filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.quotas)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename, data)
+}
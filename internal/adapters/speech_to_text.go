@@ -0,0 +1,74 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// NewSpeechToTextFromConfig returns domain.SpeechToText of the configured provider,
+// it uses the same endpoint and credentials as the chat completions.
+func NewSpeechToTextFromConfig(cfg GPTConfig) (domain.SpeechToText, error) {
+	switch cfg.Provider {
+	case GPTProviderOpenAI, GPTProviderOpenAICompatible, "":
+		return NewWhisperSpeechToText(cfg), nil
+	case GPTProviderFake:
+		return NewFakeSpeechToText(""), nil
+	}
+	return nil, fmt.Errorf("unknown GPT provider %q", cfg.Provider)
+}
+
+// WhisperSpeechToText transcribes audio by the OpenAI transcriptions API.
+type WhisperSpeechToText struct {
+	*openai.Client
+	model string
+}
+
+func NewWhisperSpeechToText(cfg GPTConfig) *WhisperSpeechToText {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	model := cfg.TranscriptionModel
+	if model == "" {
+		model = openai.Whisper1
+	}
+	return &WhisperSpeechToText{
+		Client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (c *WhisperSpeechToText) Transcribe(ctx context.Context, audio []byte, fileName string) (string, error) {
+	resp, err := c.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    c.model,
+		FilePath: fileName,
+		Reader:   bytes.NewReader(audio),
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// FakeSpeechToText returns the fixed transcript without calling any API, for tests and local runs.
+type FakeSpeechToText struct {
+	text string
+}
+
+// NewFakeSpeechToText returns the transcript with the audio size if the text is empty.
+func NewFakeSpeechToText(text string) *FakeSpeechToText {
+	return &FakeSpeechToText{text: text}
+}
+
+func (c *FakeSpeechToText) Transcribe(ctx context.Context, audio []byte, fileName string) (string, error) {
+	if c.text != "" {
+		return c.text, nil
+	}
+	return fmt.Sprintf("Voice message %s of %d bytes", fileName, len(audio)), nil
+}
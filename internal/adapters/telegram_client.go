@@ -2,6 +2,9 @@ package adapters
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
 	"github.com/xenking/managed-tg-gpt-chat/pkg/echotron"
@@ -32,18 +35,7 @@ func (c *TelegramClient) SetBotCommands(ctx context.Context, commands []domain.B
 }
 
 func (c *TelegramClient) SendMessageHTMLWithInlineKeyboard(ctx context.Context, chatID int64, message string, buttons []domain.KeyboardButton) (*domain.TelegramMessage, error) {
-	var inlineKeyboard [][]echotron.InlineKeyboardButton
-	for _, b := range buttons {
-		inlineKeyboard = append(inlineKeyboard, []echotron.InlineKeyboardButton{
-			{
-				Text:         b.Text,
-				CallbackData: b.CallbackData,
-			},
-		})
-	}
-	replyMarkup := echotron.InlineKeyboardMarkup{
-		InlineKeyboard: inlineKeyboard,
-	}
+	replyMarkup := newInlineKeyboardMarkup(buttons)
 	opts := &echotron.MessageOptions{
 		ParseMode:   echotron.HTML,
 		ReplyMarkup: &replyMarkup,
@@ -69,6 +61,19 @@ func (c *TelegramClient) SendMessage(ctx context.Context, chatID int64, message
 	return nil
 }
 
+func (c *TelegramClient) SendMessageWithResult(ctx context.Context, chatID int64, message string) (*domain.TelegramMessage, error) {
+	res, err := c.API.SendMessage(ctx, message, chatID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.TelegramMessage{
+		Text:     res.Result.Text,
+		ID:       res.Result.ID,
+		Entities: domain.ParseTelegramMessageEntities(res.Result.Entities),
+	}, nil
+}
+
 func (c *TelegramClient) SendMessageHTML(ctx context.Context, chatID int64, message string) error {
 	// message = echotron.EscapeHTMLMessage(message)
 	opts := &echotron.MessageOptions{
@@ -82,6 +87,22 @@ func (c *TelegramClient) SendMessageHTML(ctx context.Context, chatID int64, mess
 	return nil
 }
 
+func (c *TelegramClient) SendMessageHTMLWithResult(ctx context.Context, chatID int64, message string) (*domain.TelegramMessage, error) {
+	opts := &echotron.MessageOptions{
+		ParseMode: echotron.HTML,
+	}
+	res, err := c.API.SendMessage(ctx, message, chatID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.TelegramMessage{
+		Text:     res.Result.Text,
+		ID:       res.Result.ID,
+		Entities: domain.ParseTelegramMessageEntities(res.Result.Entities),
+	}, nil
+}
+
 func (c *TelegramClient) ReplyToMessageHTML(ctx context.Context, chatID int64, messageID int, message string) error {
 	// message = echotron.EscapeHTMLMessage(message)
 	opts := &echotron.MessageOptions{
@@ -99,12 +120,40 @@ func (c *TelegramClient) ReplyToMessageHTML(ctx context.Context, chatID int64, m
 	return nil
 }
 
+func (c *TelegramClient) ReplyToMessageWithPhoto(ctx context.Context, chatID int64, messageID int, fileID string, caption string) error {
+	opts := &echotron.PhotoOptions{
+		Caption: caption,
+		ReplyParameters: echotron.ReplyParameters{
+			MessageID: messageID,
+			ChatID:    chatID,
+		},
+	}
+	_, err := c.API.SendPhoto(ctx, echotron.NewInputFileID(fileID), chatID, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *TelegramClient) DownloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	res, err := c.API.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if res.Result == nil || res.Result.FilePath == "" {
+		return nil, fmt.Errorf("file %s is not available for download", fileID)
+	}
+
+	return c.API.DownloadFile(ctx, res.Result.FilePath)
+}
+
 func (c *TelegramClient) EditMessage(ctx context.Context, chatID int64, msg domain.TelegramMessage) error {
 	opts := &echotron.MessageTextOptions{
 		Entities: msg.Entities,
 	}
 	_, err := c.API.EditMessageText(ctx, msg.Text, echotron.NewMessageID(chatID, msg.ID), opts)
-	if err != nil {
+	if err != nil && !isMessageNotModified(err) {
 		return err
 	}
 
@@ -118,9 +167,58 @@ func (c *TelegramClient) EditMessageHTML(ctx context.Context, chatID int64, msg
 		Entities:  msg.Entities,
 	}
 	_, err := c.API.EditMessageText(ctx, msg.Text, echotron.NewMessageID(chatID, msg.ID), opts)
+	if err != nil && !isMessageNotModified(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *TelegramClient) EditMessageHTMLWithInlineKeyboard(ctx context.Context, chatID int64, msg domain.TelegramMessage, buttons []domain.KeyboardButton) error {
+	opts := &echotron.MessageTextOptions{
+		ParseMode:   echotron.HTML,
+		Entities:    msg.Entities,
+		ReplyMarkup: newInlineKeyboardMarkup(buttons),
+	}
+	_, err := c.API.EditMessageText(ctx, msg.Text, echotron.NewMessageID(chatID, msg.ID), opts)
+	if err != nil && !isMessageNotModified(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *TelegramClient) AnswerCallbackQuery(ctx context.Context, callbackID string, text string, alert bool) error {
+	opts := &echotron.CallbackQueryOptions{
+		Text:      text,
+		ShowAlert: alert,
+	}
+	_, err := c.API.AnswerCallbackQuery(ctx, callbackID, opts)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// isMessageNotModified tells whether the edit failed because the message already has the text,
+// e.g. the edit is retried after the previous attempt succeeded.
+func isMessageNotModified(err error) bool {
+	var apiErr *echotron.APIError
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Description(), "message is not modified")
+}
+
+func newInlineKeyboardMarkup(buttons []domain.KeyboardButton) echotron.InlineKeyboardMarkup {
+	var inlineKeyboard [][]echotron.InlineKeyboardButton
+	for _, b := range buttons {
+		inlineKeyboard = append(inlineKeyboard, []echotron.InlineKeyboardButton{
+			{
+				Text:         b.Text,
+				CallbackData: b.CallbackData,
+			},
+		})
+	}
+	return echotron.InlineKeyboardMarkup{
+		InlineKeyboard: inlineKeyboard,
+	}
+}
@@ -0,0 +1,58 @@
+package adapters
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// DocumentTextExtractor reads plain text files, such as source code and logs, and PDFs.
+type DocumentTextExtractor struct{}
+
+func NewDocumentTextExtractor() *DocumentTextExtractor {
+	return &DocumentTextExtractor{}
+}
+
+func (e *DocumentTextExtractor) Extract(fileName, mimeType string, data []byte) (string, error) {
+	if mimeType == "application/pdf" || strings.EqualFold(filepath.Ext(fileName), ".pdf") {
+		return extractPDFText(data)
+	}
+	// Source files are often sent as application/octet-stream, so the content decides
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%w: %s is not a text file", domain.ErrUnsupportedAttachment, fileName)
+	}
+	return string(data), nil
+}
+
+func extractPDFText(data []byte) (text string, err error) {
+	defer func() {
+		// The PDF reader panics on some malformed documents
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: malformed PDF: %v", domain.ErrUnsupportedAttachment, r)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", domain.ErrUnsupportedAttachment, err)
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", domain.ErrUnsupportedAttachment, err)
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(string(content))
+	if text == "" {
+		return "", fmt.Errorf("%w: the PDF has no text, e.g. it is scanned", domain.ErrUnsupportedAttachment)
+	}
+	return text, nil
+}
@@ -0,0 +1,64 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// maxExpressionLength keeps the evaluation of the constant expression cheap.
+const maxExpressionLength = 256
+
+// CalculatorTool evaluates arithmetic expressions exactly, models are not good at it.
+type CalculatorTool struct{}
+
+func NewCalculatorTool() *CalculatorTool {
+	return &CalculatorTool{}
+}
+
+func (t *CalculatorTool) Definition() domain.ToolDefinition {
+	return domain.ToolDefinition{
+		Name: "calculate",
+		Description: "Evaluates the arithmetic expression with Go syntax, e.g. (1.5+2)*3 or 1<<20. " +
+			"Integer division truncates, write 7.0/2 to get 3.5.",
+		Parameters: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "expression": {"type": "string", "description": "Arithmetic expression"}
+  },
+  "required": ["expression"]
+}`),
+	}
+}
+
+func (t *CalculatorTool) Call(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	err := json.Unmarshal([]byte(arguments), &args)
+	if err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if len(args.Expression) > maxExpressionLength {
+		return "", fmt.Errorf("expressions up to %d characters are supported", maxExpressionLength)
+	}
+	// Constant expressions are evaluated with arbitrary precision by the Go type checker
+	tv, err := types.Eval(token.NewFileSet(), nil, token.NoPos, args.Expression)
+	if err != nil {
+		return "", err
+	}
+	if tv.Value == nil {
+		return "", fmt.Errorf("%s is not a constant expression", args.Expression)
+	}
+	if tv.Value.Kind() == constant.Float {
+		f, _ := constant.Float64Val(tv.Value)
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	}
+	return tv.Value.ExactString(), nil
+}
@@ -0,0 +1,49 @@
+package adapters_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/adapters"
+)
+
+func TestCalculatorTool(t *testing.T) {
+	tool := adapters.NewCalculatorTool()
+	tests := []struct {
+		expression string
+		result     string
+		fails      bool
+	}{
+		{"2+2", "4", false},
+		{"(1.5+2)*3", "10.5", false},
+		{"7/2", "3", false},
+		{"7.0/2", "3.5", false},
+		{"-7 % 3", "-1", false},
+		{"1<<20", "1048576", false},
+		{"1<<100", "1267650600228229401496703205376", false},
+		{"0.1+0.2", "0.3", false},
+		{"1/0", "", true},
+		{"x+1", "", true},
+		{"os.Exit(1)", "", true},
+		{"func() int { return 1 }()", "", true},
+		{"2+", "", true},
+		{strings.Repeat("1+", 200) + "1", "", true},
+	}
+	for _, tt := range tests {
+		arguments := `{"expression": "` + tt.expression + `"}`
+		result, err := tool.Call(context.Background(), arguments)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("Call(%q) = %q, expected error", tt.expression, result)
+			}
+			continue
+		}
+		if err != nil || result != tt.result {
+			t.Errorf("Call(%q) = %q, %v, expected %q", tt.expression, result, err, tt.result)
+		}
+	}
+	if _, err := tool.Call(context.Background(), "2+2"); err == nil {
+		t.Errorf("Call with invalid arguments, expected error")
+	}
+}
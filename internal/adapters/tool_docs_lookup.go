@@ -0,0 +1,111 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// maxDocsLookupResults is the number of the best matching paragraphs returned to the model.
+const maxDocsLookupResults = 3
+
+// DocsLookupTool searches the internal docs, markdown and text files of the directory.
+type DocsLookupTool struct {
+	dir string
+}
+
+func NewDocsLookupTool(dir string) *DocsLookupTool {
+	return &DocsLookupTool{dir: dir}
+}
+
+func (t *DocsLookupTool) Definition() domain.ToolDefinition {
+	return domain.ToolDefinition{
+		Name:        "search_docs",
+		Description: "Searches the internal docs of the team, e.g. processes, conventions and services.",
+		Parameters: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "query": {"type": "string", "description": "Keywords to search for"}
+  },
+  "required": ["query"]
+}`),
+	}
+}
+
+type docsParagraph struct {
+	file  string
+	text  string
+	score int
+}
+
+func (t *DocsLookupTool) Call(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	err := json.Unmarshal([]byte(arguments), &args)
+	if err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	var terms []string
+	for _, term := range strings.Fields(strings.ToLower(args.Query)) {
+		if len([]rune(term)) >= 3 {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return "", errors.New("the query has no keywords")
+	}
+
+	var found []docsParagraph
+	err = filepath.WalkDir(t.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext != ".md" && ext != ".txt" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file, _ := filepath.Rel(t.dir, path)
+		for _, text := range strings.Split(string(data), "\n\n") {
+			lower := strings.ToLower(text)
+			score := 0
+			for _, term := range terms {
+				score += strings.Count(lower, term)
+			}
+			if score > 0 {
+				found = append(found, docsParagraph{file: file, text: strings.TrimSpace(text), score: score})
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return "No docs available", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(found) == 0 {
+		return "Nothing found", nil
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+	var sb strings.Builder
+	for i, p := range found[:min(len(found), maxDocsLookupResults)] {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		fmt.Fprintf(&sb, "From %s:\n%s", p.file, p.text)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,140 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// maxFetchedPageSize bounds the downloaded page, only its text is passed to the model.
+const maxFetchedPageSize = 1 << 20
+
+// FetchURLTool lets the model read pages of the allowed hosts and their subdomains.
+type FetchURLTool struct {
+	client       *http.Client
+	allowedHosts []string
+}
+
+func NewFetchURLTool(allowedHosts []string, timeout time.Duration) *FetchURLTool {
+	t := &FetchURLTool{allowedHosts: allowedHosts}
+	t.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return t.checkURL(req.URL)
+		},
+	}
+	return t
+}
+
+func (t *FetchURLTool) Definition() domain.ToolDefinition {
+	return domain.ToolDefinition{
+		Name: "fetch_url",
+		Description: fmt.Sprintf("Fetches the web page and returns its text. Only pages of these hosts are allowed: %s.",
+			strings.Join(t.allowedHosts, ", ")),
+		Parameters: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "url": {"type": "string", "description": "Absolute http or https URL of the page"}
+  },
+  "required": ["url"]
+}`),
+	}
+}
+
+func (t *FetchURLTool) Call(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+	err := json.Unmarshal([]byte(arguments), &args)
+	if err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	u, err := url.Parse(args.URL)
+	if err != nil {
+		return "", err
+	}
+	err = t.checkURL(u)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchedPageSize))
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return htmlText(string(body)), nil
+	}
+	return string(body), nil
+}
+
+func (t *FetchURLTool) checkURL(u *url.URL) error {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range t.allowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not allowed", host)
+}
+
+// htmlText returns the visible text of the page without scripts and styles.
+func htmlText(page string) string {
+	var (
+		sb   strings.Builder
+		skip int
+	)
+	tokenizer := html.NewTokenizer(strings.NewReader(page))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(sb.String())
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); isHiddenTag(string(name)) {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); isHiddenTag(string(name)) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			text := strings.TrimSpace(string(tokenizer.Text()))
+			if skip == 0 && text != "" {
+				sb.WriteString(text)
+				sb.WriteByte('\n')
+			}
+		}
+	}
+}
+
+func isHiddenTag(name string) bool {
+	return name == "script" || name == "style" || name == "noscript"
+}
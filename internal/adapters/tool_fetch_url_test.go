@@ -0,0 +1,92 @@
+package adapters
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchURLToolCheckURL(t *testing.T) {
+	tool := NewFetchURLTool([]string{"go.dev", "pkg.go.dev"}, time.Second)
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://go.dev/doc", true},
+		{"http://go.dev/", true},
+		{"https://GO.DEV/doc", true},
+		{"https://tip.go.dev/doc", true},
+		{"https://go.dev:8443/doc", true},
+		{"https://pkg.go.dev/net/http", true},
+		{"https://evilgo.dev/", false},
+		{"https://go.dev.evil.com/", false},
+		{"https://evil.com/go.dev", false},
+		{"https://evil.com/?host=go.dev", false},
+		{"https://go.dev@evil.com/", false},
+		{"https://evil.com#.go.dev", false},
+		{"https://dev/", false},
+		{"ftp://go.dev/", false},
+		{"file:///etc/passwd", false},
+		{"javascript:alert(1)", false},
+		{"//go.dev/doc", false},
+		{"http:///doc", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Errorf("url.Parse(%q): %v", tt.url, err)
+			continue
+		}
+		err = tool.checkURL(u)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkURL(%q) = %v, expected allowed %t", tt.url, err, tt.allowed)
+		}
+	}
+}
+
+func TestFetchURLToolRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html><script>secret()</script><p>Hello</p></html>"))
+		case "/local":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/external":
+			http.Redirect(w, r, "https://evil.com/", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, server.URL+"/loop", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	host, _, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	tool := NewFetchURLTool([]string{host}, time.Second)
+
+	tests := []struct {
+		path   string
+		result string
+		err    string
+	}{
+		{"/page", "Hello", ""},
+		{"/local", "Hello", ""},
+		{"/external", "", "host evil.com is not allowed"},
+		{"/loop", "", "too many redirects"},
+	}
+	for _, tt := range tests {
+		result, err := tool.Call(context.Background(), `{"url": "`+server.URL+tt.path+`"}`)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Call(%s) error = %v, expected %q", tt.path, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result != tt.result {
+			t.Errorf("Call(%s) = %q, %v, expected %q", tt.path, result, err, tt.result)
+		}
+	}
+}
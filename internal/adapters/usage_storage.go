@@ -0,0 +1,239 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// UsageStorage aggregates token usage per user and day, and per workflow.
+// Usage before the current month is pruned, it's no longer counted by the quotas.
+// With the filename the totals are kept in a JSON file to survive restarts,
+// the file is written by Flush and Close.
+type UsageStorage struct {
+	filename string
+	// days holds the daily totals per chat.
+	days      map[int64]map[time.Time]domain.TokenUsage
+	workflows map[string]workflowUsage
+	dirty     bool
+	mu        sync.RWMutex
+}
+
+type workflowUsage struct {
+	ChatID int64
+	// Day is the last day of the usage, the workflow is pruned with it.
+	Day   time.Time
+	Usage domain.TokenUsage
+}
+
+type dailyUsage struct {
+	ChatID int64
+	Day    time.Time
+	Usage  domain.TokenUsage
+}
+
+type usageFile struct {
+	Days      []dailyUsage
+	Workflows map[string]workflowUsage
+}
+
+var _ domain.UsageStorage = (*UsageStorage)(nil)
+
+// NewUsageStorage loads the totals from the file, empty filename keeps them in memory only.
+func NewUsageStorage(filename string) (*UsageStorage, error) {
+	us := &UsageStorage{
+		filename:  filename,
+		days:      make(map[int64]map[time.Time]domain.TokenUsage),
+		workflows: make(map[string]workflowUsage),
+	}
+	if filename == "" {
+		return us, nil
+	}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return us, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return us, nil
+	}
+	var file usageFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+	for _, day := range file.Days {
+		us.add(day.ChatID, "", day.Day, day.Usage)
+	}
+	for workflowID, usage := range file.Workflows {
+		us.workflows[workflowID] = usage
+	}
+	us.prune(time.Now())
+	return us, nil
+}
+
+func (us *UsageStorage) Add(ctx context.Context, record domain.UsageRecord) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	if us.prune(record.Time) {
+		us.dirty = true
+	}
+	us.add(record.ChatID, record.WorkflowID, domain.StartOfDay(record.Time), record.Usage)
+	us.dirty = true
+	return nil
+}
+
+func (us *UsageStorage) Get(ctx context.Context, filter domain.UsageFilter) (domain.TokenUsage, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	var total domain.TokenUsage
+	if filter.WorkflowIDPrefix != "" {
+		for workflowID, usage := range us.workflows {
+			if !strings.HasPrefix(workflowID, filter.WorkflowIDPrefix) {
+				continue
+			}
+			if filter.ChatID != 0 && usage.ChatID != filter.ChatID {
+				continue
+			}
+			total.Add(usage.Usage)
+		}
+		return total, nil
+	}
+	sumDays := func(days map[time.Time]domain.TokenUsage) {
+		for day, usage := range days {
+			if !filter.From.IsZero() && day.Before(domain.StartOfDay(filter.From)) {
+				continue
+			}
+			if !filter.To.IsZero() && !day.Before(filter.To) {
+				continue
+			}
+			total.Add(usage)
+		}
+	}
+	if filter.ChatID != 0 {
+		sumDays(us.days[filter.ChatID])
+		return total, nil
+	}
+	for _, days := range us.days {
+		sumDays(days)
+	}
+	return total, nil
+}
+
+// Flush writes the totals added since the previous flush.
+func (us *UsageStorage) Flush() error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	if !us.dirty {
+		return nil
+	}
+	return us.flush()
+}
+
+// Close writes the totals which are not flushed yet.
+func (us *UsageStorage) Close() error {
+	return us.Flush()
+}
+
+// add must be called with the lock held or before the storage is shared.
+func (us *UsageStorage) add(chatID int64, workflowID string, day time.Time, usage domain.TokenUsage) {
+	days := us.days[chatID]
+	if days == nil {
+		days = make(map[time.Time]domain.TokenUsage)
+		us.days[chatID] = days
+	}
+	total := days[day]
+	total.Add(usage)
+	days[day] = total
+	if workflowID == "" {
+		return
+	}
+	wu := us.workflows[workflowID]
+	wu.ChatID = chatID
+	if day.After(wu.Day) {
+		wu.Day = day
+	}
+	wu.Usage.Add(usage)
+	us.workflows[workflowID] = wu
+}
+
+// prune drops the usage before the month of now and tells whether anything was dropped.
+// It must be called with the lock held or before the storage is shared.
+func (us *UsageStorage) prune(now time.Time) bool {
+	monthStart := domain.StartOfMonth(now)
+	pruned := false
+	for chatID, days := range us.days {
+		for day := range days {
+			if day.Before(monthStart) {
+				delete(days, day)
+				pruned = true
+			}
+		}
+		if len(days) == 0 {
+			delete(us.days, chatID)
+		}
+	}
+	for workflowID, usage := range us.workflows {
+		if usage.Day.Before(monthStart) {
+			delete(us.workflows, workflowID)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// flush must be called with the lock held.
+func (us *UsageStorage) flush() error {
+	if us.filename == "" {
+		return nil
+	}
+	var file usageFile
+	for chatID, days := range us.days {
+		for day, usage := range days {
+			file.Days = append(file.Days, dailyUsage{ChatID: chatID, Day: day, Usage: usage})
+		}
+	}
+	file.Workflows = us.workflows
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(us.filename, data)
+	if err != nil {
+		return err
+	}
+	us.dirty = false
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to not corrupt the file on crash.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
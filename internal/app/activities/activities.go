@@ -11,18 +11,33 @@ type Activities struct {
 	TelegramClient domain.TelegramClient
 	GPTClient      domain.GPTClient
 	HTMlConverter  domain.MarkdownHTMLConverter
-	TokensStorage  domain.ActivitiesTokenStorage
+	CallbackCodec  domain.ApprovalCallbackCodec
+	UsageStorage   domain.UsageStorage
+	QuotaStorage   domain.QuotaStorage
+	Tools          *domain.ToolRegistry
+	Knowledge      domain.KnowledgeBase
+	Moderator      domain.Moderator
 }
 
 func New(cli client.Client, tgCli domain.TelegramClient, gptClient domain.GPTClient,
-	storage domain.ActivitiesTokenStorage,
+	codec domain.ApprovalCallbackCodec,
+	usage domain.UsageStorage,
+	quotas domain.QuotaStorage,
+	tools *domain.ToolRegistry,
+	knowledge domain.KnowledgeBase,
+	moderator domain.Moderator,
 	converter domain.MarkdownHTMLConverter,
 ) *Activities {
 	return &Activities{
 		Client:         cli,
 		TelegramClient: tgCli,
 		GPTClient:      gptClient,
-		TokensStorage:  storage,
+		CallbackCodec:  codec,
+		UsageStorage:   usage,
+		QuotaStorage:   quotas,
+		Tools:          tools,
+		Knowledge:      knowledge,
+		Moderator:      moderator,
 		HTMlConverter:  converter,
 	}
 }
@@ -0,0 +1,71 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+type UpdateApprovalVotesRequest struct {
+	ChannelID int64
+	Callback  domain.ApprovalCallback
+	Message   domain.TelegramMessage
+	Quorum    domain.QuorumPolicy
+	Votes     []domain.ApprovalVote
+}
+
+// UpdateApprovalVotes shows the recorded votes in the approval message.
+// The keyboard is kept with the live tally until the quorum is decided.
+func (a *Activities) UpdateApprovalVotes(ctx context.Context, req UpdateApprovalVotesRequest) error {
+	_, _, status := req.Quorum.TallyVotes(req.Votes)
+
+	var sb strings.Builder
+	sb.WriteString(html.EscapeString(req.Message.Text))
+	sb.WriteString("\n\nVotes:")
+	for _, vote := range req.Votes {
+		mark := "✅"
+		if vote.Status == domain.RequestStatusRejected {
+			mark = "❌"
+		}
+		fmt.Fprintf(&sb, "\n%s @%s", mark, html.EscapeString(vote.VoterName))
+	}
+	msg := domain.TelegramMessage{
+		ID:       req.Message.ID,
+		Entities: req.Message.Entities,
+	}
+	if status != domain.RequestStatusPending {
+		fmt.Fprintf(&sb, "\n\nStatus: <b>%s</b>", status)
+		msg.Text = sb.String()
+		return a.TelegramClient.EditMessageHTML(ctx, req.ChannelID, msg)
+	}
+	msg.Text = sb.String()
+	buttons, err := a.makeApprovalButtons(req.Callback, &req.Quorum, req.Votes)
+	if err != nil {
+		return err
+	}
+	return a.TelegramClient.EditMessageHTMLWithInlineKeyboard(ctx, req.ChannelID, msg, buttons)
+}
+
+type CompleteApprovalRequest struct {
+	WorkflowID string
+	ActivityID string
+	Response   GetRequestApprovalResponse
+}
+
+// CompleteApproval completes the pending GetRequestApproval activity once its quorum is decided.
+func (a *Activities) CompleteApproval(ctx context.Context, req CompleteApprovalRequest) error {
+	err := a.Client.CompleteActivityByID(ctx, client.DefaultNamespace, req.WorkflowID, "", req.ActivityID, req.Response, nil)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		// Completed by the previous attempt
+		return nil
+	}
+	return err
+}
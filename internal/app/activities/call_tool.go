@@ -0,0 +1,46 @@
+package activities
+
+import (
+	"context"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// maxToolResultLength keeps tool results within the context window of the model.
+const maxToolResultLength = 8000
+
+type CallToolRequest struct {
+	Call domain.ToolCall
+}
+
+type CallToolResponse struct {
+	// Message is the tool message answering the call.
+	Message domain.ChatMessage
+}
+
+// CallTool runs the tool requested by the model. Tool errors are returned to the model
+// as the result, so that it can fix the arguments or answer without the tool.
+func (a *Activities) CallTool(ctx context.Context, req CallToolRequest) (CallToolResponse, error) {
+	result, err := a.Tools.Call(ctx, req.Call)
+	if err != nil {
+		result = "Error: " + err.Error()
+	}
+	if runes := []rune(result); len(runes) > maxToolResultLength {
+		result = string(runes[:maxToolResultLength]) + "\n… truncated"
+	}
+	return CallToolResponse{
+		Message: domain.ChatMessage{
+			Role:       domain.ChatMessageRoleTool,
+			Content:    result,
+			ToolCallID: req.Call.ID,
+		},
+	}, nil
+}
+
+// withTools offers the registered tools to the model unless the workflow disabled them.
+func (a *Activities) withTools(opts domain.ChatOptions) domain.ChatOptions {
+	if !opts.NoTools {
+		opts.Tools = a.Tools.Definitions()
+	}
+	return opts
+}
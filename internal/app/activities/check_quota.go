@@ -0,0 +1,105 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// quotaNoteShare is the used share of a budget worth telling approvers about.
+const quotaNoteShare = 0.5
+
+type CheckQuotaRequest struct {
+	ChatID int64
+	Policy domain.QuotaPolicy
+}
+
+type CheckQuotaResponse struct {
+	Status domain.QuotaStatus
+	// Used is the largest used share of the budgets.
+	Used float64
+	// Message lists the budgets over or near the limit.
+	Message string
+}
+
+// CheckQuota compares the spending of the user and in total with the budgets of the policy.
+// Budgets raised by admins replace the user budgets of the policy.
+func (a *Activities) CheckQuota(ctx context.Context, req CheckQuotaRequest) (CheckQuotaResponse, error) {
+	userDaily, userMonthly := req.Policy.UserDaily, req.Policy.UserMonthly
+	if quota, ok := a.QuotaStorage.Get(req.ChatID); ok {
+		userDaily, userMonthly = quota.Daily, quota.Monthly
+	}
+
+	now := time.Now()
+	checks := []struct {
+		name   string
+		budget domain.Budget
+		filter domain.UsageFilter
+	}{
+		{"user daily", userDaily, domain.UsageFilter{ChatID: req.ChatID, From: domain.StartOfDay(now)}},
+		{"user monthly", userMonthly, domain.UsageFilter{ChatID: req.ChatID, From: domain.StartOfMonth(now)}},
+		{"total daily", req.Policy.TotalDaily, domain.UsageFilter{From: domain.StartOfDay(now)}},
+		{"total monthly", req.Policy.TotalMonthly, domain.UsageFilter{From: domain.StartOfMonth(now)}},
+	}
+	var (
+		maxShare float64
+		notes    []string
+	)
+	for _, check := range checks {
+		if check.budget == (domain.Budget{}) {
+			continue
+		}
+		usage, err := a.UsageStorage.Get(ctx, check.filter)
+		if err != nil {
+			return CheckQuotaResponse{}, err
+		}
+		share := check.budget.Share(usage)
+		maxShare = max(maxShare, share)
+		if share >= quotaNoteShare {
+			notes = append(notes, fmt.Sprintf("%s budget %.0f%% used", check.name, share*100))
+		}
+	}
+
+	resp := CheckQuotaResponse{
+		Status:  domain.QuotaStatusWithin,
+		Used:    maxShare,
+		Message: strings.Join(notes, ", "),
+	}
+	switch {
+	case maxShare >= 1:
+		resp.Status = domain.QuotaStatusOver
+	case maxShare < req.Policy.AutoApproveBelow:
+		resp.Status = domain.QuotaStatusUnder
+	}
+	return resp, nil
+}
+
+type PostResolvedRequestRequest struct {
+	Request GetRequestApprovalRequest
+	Status  domain.RequestStatus
+	Reason  string
+}
+
+// PostResolvedRequest posts the request resolved without approvers to the audit channel,
+// so that it is logged and commented with the response like the approved ones.
+func (a *Activities) PostResolvedRequest(ctx context.Context, req PostResolvedRequestRequest) (GetRequestApprovalResponse, error) {
+	content, err := a.formatApprovalRequest(ctx, req.Request)
+	if err != nil {
+		return GetRequestApprovalResponse{}, err
+	}
+	content += fmt.Sprintf("\n\nStatus: <b>%s</b> automatically: %s", req.Status, html.EscapeString(req.Reason))
+	msg, err := a.TelegramClient.SendMessageHTMLWithResult(ctx, req.Request.ChannelID, content)
+	if err != nil {
+		return GetRequestApprovalResponse{}, err
+	}
+	a.postRequestImages(ctx, req.Request.ChannelID, msg.ID, req.Request.Images)
+	return GetRequestApprovalResponse{
+		MessageID: msg.ID,
+		Message:   fmt.Sprintf("Request %s automatically: %s", req.Status, req.Reason),
+		Status:    req.Status,
+	}, nil
+}
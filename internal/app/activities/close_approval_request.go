@@ -0,0 +1,30 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"html"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+type CloseApprovalRequestRequest struct {
+	ChannelID int64
+	Message   domain.TelegramMessage
+	Status    domain.RequestStatus
+	Reason    string
+}
+
+// CloseApprovalRequest marks the approval message resolved without an approver decision
+// and removes its keyboard.
+func (a *Activities) CloseApprovalRequest(ctx context.Context, req CloseApprovalRequestRequest) error {
+	text := fmt.Sprintf("%s\n\nStatus: <b>%s</b>", html.EscapeString(req.Message.Text), req.Status)
+	if req.Reason != "" {
+		text += fmt.Sprintf(" (%s)", html.EscapeString(req.Reason))
+	}
+	return a.TelegramClient.EditMessageHTML(ctx, req.ChannelID, domain.TelegramMessage{
+		Text:     text,
+		ID:       req.Message.ID,
+		Entities: req.Message.Entities,
+	})
+}
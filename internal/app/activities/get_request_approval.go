@@ -3,8 +3,11 @@ package activities
 import (
 	"context"
 	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/google/uuid"
 	"go.temporal.io/sdk/activity"
 
 	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
@@ -15,37 +18,86 @@ type GetRequestApprovalRequest struct {
 	ChatID       int64
 	ChatUserName string
 	Request      string
+	// Images are shown in reply to the request.
+	Images []domain.ChatImage
+	// Attachments are listed to approvers.
+	Attachments []domain.Attachment
+	// Model is shown to approvers to weigh the cost of the request.
+	Model   string
+	Persona string
+	// History is the conversation context shown to approvers of follow-up questions.
+	History []domain.ChatMessage
+	// EscalatedAfter is set when the request is escalated after waiting for a decision.
+	EscalatedAfter time.Duration
+	// Quorum is set when the request needs the votes of several approvers.
+	Quorum *domain.QuorumPolicy
+	// Quota explains the spending of the user over or near the budget.
+	Quota string
+	// Rule is the approval rule that matched the request.
+	Rule string
+	// Flags describe the sensitive data redacted in the Request and the moderation categories.
+	Flags string
+	// Redacted is set if the Request differs from the request of the user.
+	Redacted bool
+}
+
+// ApprovalRequestedSignal notifies the workflow about the posted approval message.
+const ApprovalRequestedSignal = "approval-requested-signal"
+
+type ApprovalRequestedSignalInput struct {
+	ChannelID int64
+	Message   domain.TelegramMessage
+	// Callback references the pending approval activity, the status is set per button.
+	Callback domain.ApprovalCallback
 }
 
 type GetRequestApprovalResponse struct {
 	MessageID int
-	Message   string // TODO
-	Status    domain.RequestStatus
+	// Message explains the decision to the user, e.g. the reject reason.
+	Message string
+	Status  domain.RequestStatus
+	// EditedRequest replaces the original request when the approver edited it.
+	EditedRequest string
+	// DecidedBy is the approver who made the decision, zero if the request was resolved automatically.
+	DecidedBy     int64
+	DecidedByName string
 }
 
 func (a *Activities) GetRequestApproval(ctx context.Context, req GetRequestApprovalRequest) (GetRequestApprovalResponse, error) {
 	// Retrieve the Activity information needed to asynchronously complete the Activity.
 	activityInfo := activity.GetInfo(ctx)
-	taskToken := activityInfo.TaskToken
-	tokenID := a.TokensStorage.Store(taskToken)
-	buttons := []domain.KeyboardButton{
-		{
-			Text:         "Approve",
-			CallbackData: makeCallbackData(tokenID, domain.RequestStatusApproved),
-		},
-		{
-			Text:         "Reject",
-			CallbackData: makeCallbackData(tokenID, domain.RequestStatusRejected),
-		},
+	callback := domain.ApprovalCallback{
+		WorkflowID: activityInfo.WorkflowExecution.ID,
+		ActivityID: activityInfo.ActivityID,
+		Quorum:     req.Quorum != nil,
+		ExpiresAt:  activityInfo.Deadline,
+	}
+	buttons, err := a.makeApprovalButtons(callback, req.Quorum, nil)
+	if err != nil {
+		return GetRequestApprovalResponse{}, err
+	}
+	content, err := a.formatApprovalRequest(ctx, req)
+	if err != nil {
+		return GetRequestApprovalResponse{}, err
 	}
-	content := fmt.Sprintf(`Request from <a href="tg://user?id=%d">@%s</a>:
-<blockquote>%s</blockquote>`, req.ChatID, req.ChatUserName, req.Request)
 
 	// Send a message to the chat system to request approval.
 	msg, err := a.TelegramClient.SendMessageHTMLWithInlineKeyboard(ctx, req.ChannelID, content, buttons)
 	if err != nil {
 		return GetRequestApprovalResponse{}, err
 	}
+	a.postRequestImages(ctx, req.ChannelID, msg.ID, req.Images)
+
+	// Let the workflow remind about or close the request while it is pending
+	err = a.Client.SignalWorkflow(ctx, activityInfo.WorkflowExecution.ID, activityInfo.WorkflowExecution.RunID,
+		ApprovalRequestedSignal, ApprovalRequestedSignalInput{
+			ChannelID: req.ChannelID,
+			Message:   *msg,
+			Callback:  callback,
+		})
+	if err != nil {
+		activity.GetLogger(ctx).Warn("Unable to signal approval message", "error", err)
+	}
 
 	return GetRequestApprovalResponse{
 		MessageID: msg.ID,
@@ -54,6 +106,120 @@ func (a *Activities) GetRequestApproval(ctx context.Context, req GetRequestAppro
 	}, activity.ErrResultPending
 }
 
-func makeCallbackData(tokenID uuid.UUID, action domain.RequestStatus) string {
-	return tokenID.String() + ":" + string(action[:1])
+// formatApprovalRequest describes the request to approvers,
+// the mention of the user identifies the chat of the request.
+func (a *Activities) formatApprovalRequest(ctx context.Context, req GetRequestApprovalRequest) (string, error) {
+	content := fmt.Sprintf(`Request from <a href="tg://user?id=%d">@%s</a>:
+<blockquote>%s</blockquote>`, req.ChatID, html.EscapeString(req.ChatUserName), html.EscapeString(req.Request))
+	if len(req.Attachments) > 0 {
+		content += "\n" + formatAttachments(req.Attachments)
+	}
+	if len(req.History) > 0 {
+		content += "\n" + formatApprovalHistory(req.History)
+	}
+	if req.Model != "" {
+		content += fmt.Sprintf("\nModel: <b>%s</b>", html.EscapeString(req.Model))
+	}
+	if req.Persona != "" {
+		content += fmt.Sprintf("\nPersona: <b>%s</b>", html.EscapeString(req.Persona))
+	}
+	usage, err := a.formatUserUsage(ctx, req.ChatID)
+	if err != nil {
+		return "", err
+	}
+	content += "\n" + usage
+	if req.Rule != "" {
+		content += fmt.Sprintf("\nRule: <b>%s</b>", html.EscapeString(req.Rule))
+	}
+	if req.Quota != "" {
+		content += fmt.Sprintf("\n<b>Quota:</b> %s", html.EscapeString(req.Quota))
+	}
+	if req.Flags != "" {
+		content += fmt.Sprintf("\n<b>Flagged:</b> %s", html.EscapeString(req.Flags))
+	}
+	if req.Quorum != nil {
+		content += fmt.Sprintf("\nQuorum <b>%s</b>: %d of %d approvers required",
+			req.Quorum.Name, req.Quorum.Required, len(req.Quorum.Approvers))
+	}
+	if req.EscalatedAfter > 0 {
+		content = fmt.Sprintf("<b>Escalated:</b> no decision within %s\n\n%s", req.EscalatedAfter, content)
+	}
+	return content, nil
+}
+
+// Limits of the conversation context shown to approvers.
+const (
+	maxApprovalHistoryMessages = 6
+	maxApprovalHistoryLength   = 300
+)
+
+func formatApprovalHistory(history []domain.ChatMessage) string {
+	if len(history) > maxApprovalHistoryMessages {
+		history = history[len(history)-maxApprovalHistoryMessages:]
+	}
+	var sb strings.Builder
+	sb.WriteString("Conversation so far:\n<blockquote expandable>")
+	for i, msg := range history {
+		redacted, _ := domain.RedactSensitive(msg.Content)
+		content := []rune(redacted)
+		if len(content) > maxApprovalHistoryLength {
+			content = append(content[:maxApprovalHistoryLength], '…')
+		}
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		fmt.Fprintf(&sb, "<b>%s:</b> %s", msg.Role, html.EscapeString(string(content)))
+		if len(msg.Images) > 0 {
+			fmt.Fprintf(&sb, " [%d image(s)]", len(msg.Images))
+		}
+		for _, attachment := range msg.Attachments {
+			fmt.Fprintf(&sb, " [%s]", html.EscapeString(attachment.FileName))
+		}
+	}
+	sb.WriteString("</blockquote>")
+	return sb.String()
+}
+
+func formatAttachments(attachments []domain.Attachment) string {
+	var sb strings.Builder
+	sb.WriteString("Attachments:")
+	for _, attachment := range attachments {
+		fmt.Fprintf(&sb, "\n• <code>%s</code>, %d KB, %d characters",
+			html.EscapeString(attachment.FileName), (attachment.Size+1023)/1024, utf8.RuneCountInString(attachment.Text))
+		if attachment.Truncated {
+			sb.WriteString(", truncated")
+		}
+	}
+	return sb.String()
+}
+
+func (a *Activities) makeApprovalButtons(callback domain.ApprovalCallback, quorum *domain.QuorumPolicy,
+	votes []domain.ApprovalVote,
+) ([]domain.KeyboardButton, error) {
+	approveText, rejectText := "Approve", "Reject"
+	if quorum != nil {
+		approved, rejected, _ := quorum.TallyVotes(votes)
+		approveText = fmt.Sprintf("Approve (%d/%d)", approved, quorum.Required)
+		rejectText = fmt.Sprintf("Reject (%d)", rejected)
+	}
+	callback.Status = domain.RequestStatusApproved
+	approveData, err := a.CallbackCodec.Encode(callback)
+	if err != nil {
+		return nil, err
+	}
+	callback.Status = domain.RequestStatusRejected
+	rejectData, err := a.CallbackCodec.Encode(callback)
+	if err != nil {
+		return nil, err
+	}
+	return []domain.KeyboardButton{
+		{
+			Text:         approveText,
+			CallbackData: approveData,
+		},
+		{
+			Text:         rejectText,
+			CallbackData: rejectData,
+		},
+	}, nil
 }
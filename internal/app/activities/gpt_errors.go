@@ -0,0 +1,23 @@
+package activities
+
+import (
+	"errors"
+
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// gptApplicationError stops the retries of the requests the provider won't accept on the next attempt
+// and delays the retry of the rate limited ones as the provider asked.
+// The error type is the domain.GPTErrorKind, so that the workflow can explain the failure to the user.
+func gptApplicationError(err error) error {
+	var gptErr *domain.GPTError
+	if !errors.As(err, &gptErr) {
+		return err
+	}
+	return temporal.NewApplicationErrorWithOptions(gptErr.Error(), string(gptErr.Kind), temporal.ApplicationErrorOptions{
+		NonRetryable:   !gptErr.Kind.Retryable(),
+		NextRetryDelay: gptErr.RetryAfter,
+	})
+}
@@ -0,0 +1,51 @@
+package activities
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"go.temporal.io/sdk/activity"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// resolveImages downloads the images of the messages from Telegram
+// and passes them to the model inline as data URLs.
+func (a *Activities) resolveImages(ctx context.Context, msgs []domain.ChatMessage) ([]domain.ChatMessage, error) {
+	if !domain.HasImages(msgs...) {
+		return msgs, nil
+	}
+	resolved := slices.Clone(msgs)
+	for i, msg := range resolved {
+		if len(msg.Images) == 0 {
+			continue
+		}
+		images := make([]domain.ChatImage, 0, len(msg.Images))
+		for _, image := range msg.Images {
+			data, err := a.TelegramClient.DownloadFile(ctx, image.FileID)
+			if err != nil {
+				return nil, fmt.Errorf("download image %s: %w", image.FileID, err)
+			}
+			images = append(images, domain.ChatImage{
+				FileID: image.FileID,
+				URL:    "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data),
+			})
+		}
+		resolved[i].Images = images
+	}
+	return resolved, nil
+}
+
+// postRequestImages shows approvers the images of the request in reply to the posted request.
+func (a *Activities) postRequestImages(ctx context.Context, chatID int64, messageID int, images []domain.ChatImage) {
+	for _, image := range images {
+		err := a.TelegramClient.ReplyToMessageWithPhoto(ctx, chatID, messageID, image.FileID, "Attached to the request")
+		if err != nil {
+			// The request is already posted, approvers can ask the user for the image
+			activity.GetLogger(ctx).Warn("Unable to post request image", "error", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+type ModerateContentRequest struct {
+	Request string
+	// Attachments are screened like the request, their findings are listed by the file name.
+	Attachments []domain.Attachment
+	Policy      domain.ModerationPolicy
+}
+
+type ModerateContentResponse struct {
+	// Redacted is the request to post to the audit channel.
+	Redacted   string
+	Findings   []domain.SensitiveFinding
+	Categories []string
+	// Flags describe the findings to approvers, empty if nothing was found.
+	Flags string
+	// Blocked explains why the policy rejects the request, empty if it doesn't.
+	Blocked string
+}
+
+// ModerateContent redacts the sensitive data of the request for the audit channel
+// and checks the request with its attachments by the Moderator if the policy asks for it.
+// The request is left to approvers if the Moderator is unavailable.
+func (a *Activities) ModerateContent(ctx context.Context, req ModerateContentRequest) (ModerateContentResponse, error) {
+	var resp ModerateContentResponse
+	resp.Redacted, resp.Findings = domain.RedactSensitive(req.Request)
+	texts := []moderatedText{{text: req.Request, findings: resp.Findings}}
+	for _, attachment := range req.Attachments {
+		// Attachments are not posted to the audit channel, only their findings are
+		_, findings := domain.RedactSensitive(attachment.Text)
+		texts = append(texts, moderatedText{
+			label:    attachment.FileName + ": ",
+			text:     attachment.Text,
+			findings: findings,
+		})
+	}
+
+	var flags, blocked []string
+	for _, text := range texts {
+		for _, finding := range text.findings {
+			flags = append(flags, text.label+finding.String())
+			if slices.Contains(req.Policy.Block, finding.Kind) {
+				blocked = append(blocked, text.label+finding.String())
+			}
+		}
+	}
+
+	if req.Policy.CheckContent && a.Moderator != nil {
+		for _, text := range texts {
+			categories, err := a.Moderator.Moderate(ctx, text.text)
+			if err != nil {
+				activity.GetLogger(ctx).Warn("Unable to moderate request", "error", err)
+				flags = append(flags, "moderation unavailable")
+				break
+			}
+			if len(categories) == 0 {
+				continue
+			}
+			for _, category := range categories {
+				if !slices.Contains(resp.Categories, category) {
+					resp.Categories = append(resp.Categories, category)
+				}
+			}
+			flags = append(flags, text.label+"moderation: "+strings.Join(categories, ", "))
+			if req.Policy.BlockFlagged {
+				blocked = append(blocked, text.label+strings.Join(categories, ", "))
+			}
+		}
+	}
+
+	resp.Flags = strings.Join(flags, "; ")
+	if len(blocked) > 0 {
+		resp.Blocked = fmt.Sprintf("contains %s", strings.Join(blocked, ", "))
+	}
+	return resp, nil
+}
+
+// moderatedText is the request or one of its attachments, the label names the attachment.
+type moderatedText struct {
+	label    string
+	text     string
+	findings []domain.SensitiveFinding
+}
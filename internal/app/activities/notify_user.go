@@ -0,0 +1,12 @@
+package activities
+
+import "context"
+
+type NotifyUserRequest struct {
+	ChatID  int64
+	Message string
+}
+
+func (a *Activities) NotifyUser(ctx context.Context, req NotifyUserRequest) error {
+	return a.TelegramClient.SendMessage(ctx, req.ChatID, req.Message)
+}
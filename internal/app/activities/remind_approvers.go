@@ -0,0 +1,18 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+)
+
+type RemindApproversRequest struct {
+	ChannelID    int64
+	MessageID    int
+	ChatUserName string
+	WaitingFor   string
+}
+
+func (a *Activities) RemindApprovers(ctx context.Context, req RemindApproversRequest) error {
+	return a.TelegramClient.ReplyToMessageHTML(ctx, req.ChannelID, req.MessageID,
+		fmt.Sprintf("Reminder: request from @%s is waiting for approval for %s", req.ChatUserName, req.WaitingFor))
+}
@@ -1,21 +1,44 @@
 package activities
 
-import "context"
+import (
+	"context"
+
+	"go.temporal.io/sdk/activity"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
 
 type RespondToUserRequest struct {
 	ChatID   int64
 	Messages []string
+	// EditMessageID replaces the message with the first part of the response, e.g. the streamed answer.
+	EditMessageID int
 }
 
+// RespondToUser sends the parts of the response. The number of the parts already sent is kept
+// in the heartbeat details, so that retries don't send them again.
 func (a *Activities) RespondToUser(ctx context.Context, req RespondToUserRequest) error {
 	if len(req.Messages) == 0 {
-		return a.TelegramClient.SendMessageHTML(ctx, req.ChatID, "No response from Chat GPT")
+		req.Messages = []string{"No response from Chat GPT"}
 	}
-	for _, msg := range req.Messages {
-		err := a.TelegramClient.SendMessageHTML(ctx, req.ChatID, msg)
+	var sent int
+	if activity.HasHeartbeatDetails(ctx) {
+		_ = activity.GetHeartbeatDetails(ctx, &sent)
+	}
+	for i := sent; i < len(req.Messages); i++ {
+		var err error
+		if i == 0 && req.EditMessageID != 0 {
+			err = a.TelegramClient.EditMessageHTML(ctx, req.ChatID, domain.TelegramMessage{
+				ID:   req.EditMessageID,
+				Text: req.Messages[0],
+			})
+		} else {
+			err = a.TelegramClient.SendMessageHTML(ctx, req.ChatID, req.Messages[i])
+		}
 		if err != nil {
 			return err
 		}
+		activity.RecordHeartbeat(ctx, i+1)
 	}
 	return nil
 }
@@ -0,0 +1,29 @@
+package activities
+
+import (
+	"context"
+	"strings"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+type RetrieveKnowledgeRequest struct {
+	Query string
+	TopK  int
+}
+
+type RetrieveKnowledgeResponse struct {
+	Chunks []domain.KnowledgeChunk
+}
+
+// RetrieveKnowledge searches the internal docs for the chunks relevant to the request.
+func (a *Activities) RetrieveKnowledge(ctx context.Context, req RetrieveKnowledgeRequest) (RetrieveKnowledgeResponse, error) {
+	if a.Knowledge == nil || strings.TrimSpace(req.Query) == "" {
+		return RetrieveKnowledgeResponse{}, nil
+	}
+	chunks, err := a.Knowledge.Search(ctx, req.Query, req.TopK)
+	if err != nil {
+		return RetrieveKnowledgeResponse{}, err
+	}
+	return RetrieveKnowledgeResponse{Chunks: chunks}, nil
+}
@@ -0,0 +1,119 @@
+package activities
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// streamEditInterval throttles edits of the streamed answer, it matches the default
+// per-chat request limit of echotron, which delays the edits that come more often.
+const streamEditInterval = 3 * time.Second
+
+// maxStreamPreviewLength keeps the streamed preview within a single Telegram message.
+const maxStreamPreviewLength = 4096 - 128
+
+type StreamChatGPTResponseRequest struct {
+	ChatID   int64
+	Options  domain.ChatOptions
+	Messages []domain.ChatMessage
+	// MessageID is the message of the previous call to reuse after tool calls, a new one is sent if zero.
+	MessageID int
+}
+
+type StreamChatGPTResponseResponse struct {
+	Responses []domain.ChatMessage
+	Usage     domain.TokenUsage
+	// MessageID is the message showing the streamed answer, to be replaced with the converted HTML.
+	MessageID int
+}
+
+// StreamChatGPTResponse streams the Chat GPT answer to the user, editing a single message
+// with the plain text generated so far. The message is kept in the heartbeat details,
+// so that retries don't send another one.
+func (a *Activities) StreamChatGPTResponse(ctx context.Context, req StreamChatGPTResponseRequest) (StreamChatGPTResponseResponse, error) {
+	msgs, err := a.resolveImages(ctx, req.Messages)
+	if err != nil {
+		return StreamChatGPTResponseResponse{}, err
+	}
+	msg := &domain.TelegramMessage{ID: req.MessageID}
+	if msg.ID == 0 && activity.HasHeartbeatDetails(ctx) {
+		// Retries reuse the message sent by the previous attempt
+		_ = activity.GetHeartbeatDetails(ctx, &msg.ID)
+	}
+	if msg.ID == 0 {
+		msg, err = a.TelegramClient.SendMessageWithResult(ctx, req.ChatID, "Thinking…")
+		if err != nil {
+			return StreamChatGPTResponseResponse{}, err
+		}
+	}
+	activity.RecordHeartbeat(ctx, msg.ID)
+
+	var (
+		lastEdit time.Time
+		shown    string
+	)
+	answer, err := a.GPTClient.AskStream(ctx, a.withTools(req.Options), func(content string) {
+		activity.RecordHeartbeat(ctx, msg.ID)
+		if time.Since(lastEdit) < streamEditInterval {
+			return
+		}
+		preview := makeStreamPreview(content)
+		if preview == shown {
+			return
+		}
+		lastEdit = time.Now()
+		err := a.TelegramClient.EditMessage(ctx, req.ChatID, domain.TelegramMessage{
+			ID:   msg.ID,
+			Text: preview,
+		})
+		if err != nil {
+			// The final answer replaces the preview anyway
+			activity.GetLogger(ctx).Warn("Unable to edit streamed answer", "error", err)
+			return
+		}
+		shown = preview
+	}, msgs...)
+	if err != nil {
+		return StreamChatGPTResponseResponse{}, gptApplicationError(err)
+	}
+	a.recordUsage(ctx, req.ChatID, answer.Usage)
+	if names := toolNames(answer.Response); len(names) > 0 {
+		err = a.TelegramClient.EditMessage(ctx, req.ChatID, domain.TelegramMessage{
+			ID:   msg.ID,
+			Text: "Using " + strings.Join(names, ", ") + "…",
+		})
+		if err != nil {
+			activity.GetLogger(ctx).Warn("Unable to show tool calls", "error", err)
+		}
+	}
+
+	return StreamChatGPTResponseResponse{
+		Responses: answer.Response,
+		Usage:     answer.Usage,
+		MessageID: msg.ID,
+	}, nil
+}
+
+func toolNames(msgs []domain.ChatMessage) []string {
+	var names []string
+	for _, msg := range msgs {
+		for _, call := range msg.ToolCalls {
+			names = append(names, call.Name)
+		}
+	}
+	return names
+}
+
+// makeStreamPreview shows the tail of the answer once it doesn't fit a single message.
+func makeStreamPreview(content string) string {
+	runes := []rune(content)
+	if len(runes) > maxStreamPreviewLength {
+		runes = append([]rune("…"), runes[len(runes)-maxStreamPreviewLength:]...)
+	}
+	return string(runes) + " ▍"
+}
@@ -0,0 +1,77 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+const summarizeHistoryPrompt = `You summarize the beginning of the conversation between the user and the assistant,
+the summary replaces it in the context of the next answers. 
+Keep the facts, decisions, names, code identifiers and open questions, drop greetings and repetitions. 
+Write at most 300 words in the language of the conversation.`
+
+// summaryPrefix starts the content of the summary message.
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+type SummarizeHistoryRequest struct {
+	ChatID   int64
+	Model    string
+	Messages []domain.ChatMessage
+}
+
+type SummarizeHistoryResponse struct {
+	// Summary is the system message replacing the messages.
+	Summary domain.ChatMessage
+	Usage   domain.TokenUsage
+}
+
+// SummarizeHistory summarizes the oldest turns of the conversation that no longer fit the context window.
+func (a *Activities) SummarizeHistory(ctx context.Context, req SummarizeHistoryRequest) (SummarizeHistoryResponse, error) {
+	answer, err := a.GPTClient.Ask(ctx, domain.ChatOptions{
+		Model:        req.Model,
+		SystemPrompt: summarizeHistoryPrompt,
+		NoTools:      true,
+	}, domain.ChatMessage{
+		Role:    domain.ChatMessageRoleUser,
+		Content: formatTranscript(req.Messages),
+	})
+	if err != nil {
+		return SummarizeHistoryResponse{}, gptApplicationError(err)
+	}
+	a.recordUsage(ctx, req.ChatID, answer.Usage)
+
+	var summary strings.Builder
+	for _, msg := range answer.Response {
+		summary.WriteString(msg.Content)
+	}
+	return SummarizeHistoryResponse{
+		Summary: domain.ChatMessage{
+			Role:    domain.ChatMessageRoleSystem,
+			Content: summaryPrefix + strings.TrimSpace(summary.String()),
+		},
+		Usage: answer.Usage,
+	}, nil
+}
+
+// formatTranscript presents the messages as the plain text,
+// so that the tool calls and images need no special handling.
+func formatTranscript(msgs []domain.ChatMessage) string {
+	var sb strings.Builder
+	for _, msg := range msgs {
+		fmt.Fprintf(&sb, "%s: %s", msg.Role, msg.Content)
+		for _, attachment := range msg.Attachments {
+			fmt.Fprintf(&sb, "\n[attached file %s]", attachment.FileName)
+		}
+		if len(msg.Images) > 0 {
+			fmt.Fprintf(&sb, "\n[%d image(s)]", len(msg.Images))
+		}
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&sb, "\n[called %s with %s]", call.Name, call.Arguments)
+		}
+		sb.WriteString("\n\n")
+	}
+	return sb.String()
+}
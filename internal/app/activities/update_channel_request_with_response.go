@@ -2,6 +2,9 @@ package activities
 
 import (
 	"context"
+	"html"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
 )
 
 type CommentRequestWithResponse struct {
@@ -9,15 +12,21 @@ type CommentRequestWithResponse struct {
 	MessageID int
 	Request   string
 	Responses []string
+	// Usage is the cost of the response.
+	Usage domain.TokenUsage
 }
 
+// CommentRequestWithResponse posts the answer under the request in the audit channel group,
+// redacted like the request.
 func (a *Activities) CommentRequestWithResponse(ctx context.Context, req CommentRequestWithResponse) error {
 	for _, msg := range req.Responses {
+		msg, _ = domain.RedactSensitive(msg)
 		err := a.TelegramClient.ReplyToMessageHTML(ctx, req.GroupID, req.MessageID, msg)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return a.TelegramClient.ReplyToMessageHTML(ctx, req.GroupID, req.MessageID,
+		"Usage: "+html.EscapeString(req.Usage.String()))
 }
@@ -0,0 +1,39 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// recordUsage accounts the usage of the answer to the user and the workflow of the activity.
+// The answer is already paid for, so a failure is only logged instead of asking Chat GPT again.
+func (a *Activities) recordUsage(ctx context.Context, chatID int64, usage domain.TokenUsage) {
+	err := a.UsageStorage.Add(ctx, domain.UsageRecord{
+		ChatID:     chatID,
+		WorkflowID: activity.GetInfo(ctx).WorkflowExecution.ID,
+		Time:       time.Now(),
+		Usage:      usage,
+	})
+	if err != nil {
+		activity.GetLogger(ctx).Warn("Unable to record usage", "error", err)
+	}
+}
+
+// formatUserUsage shows the spending of the user today and this month.
+func (a *Activities) formatUserUsage(ctx context.Context, chatID int64) (string, error) {
+	now := time.Now()
+	today, err := a.UsageStorage.Get(ctx, domain.UsageFilter{ChatID: chatID, From: domain.StartOfDay(now)})
+	if err != nil {
+		return "", err
+	}
+	month, err := a.UsageStorage.Get(ctx, domain.UsageFilter{ChatID: chatID, From: domain.StartOfMonth(now)})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Spent today: %s\nSpent this month: %s", today, month), nil
+}
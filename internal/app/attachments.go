@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+	"github.com/xenking/managed-tg-gpt-chat/pkg/tgrouter"
+)
+
+// Limits of the attachments, the text is truncated to fit the context window of the model.
+const (
+	maxAttachmentFileSize   = 5 << 20
+	maxAttachmentTextLength = 20_000
+)
+
+// handleDocumentMessage extracts the text of the attached document
+// and sends it to the state machine with the caption as the request.
+func (s *Service) handleDocumentMessage(ctx context.Context, u *tgrouter.Update) error {
+	sm := s.getDialogSM(u.ChatID())
+	if sm == nil {
+		return fmt.Errorf("active state machine not found for chat %d", u.ChatID())
+	}
+	doc := u.Message.Document
+	if doc.FileSize > maxAttachmentFileSize {
+		return s.telegram.SendMessage(ctx, u.ChatID(),
+			fmt.Sprintf("Files up to %d MB are supported", maxAttachmentFileSize>>20))
+	}
+	data, err := s.telegram.DownloadFile(ctx, doc.FileID)
+	if err != nil {
+		return err
+	}
+	text, err := s.documents.Extract(doc.FileName, doc.MimeType, data)
+	if errors.Is(err, domain.ErrUnsupportedAttachment) {
+		return s.telegram.SendMessage(ctx, u.ChatID(),
+			fmt.Sprintf("Unable to read %s, send source files, logs or PDFs with text", doc.FileName))
+	}
+	if err != nil {
+		return err
+	}
+
+	attachment := domain.Attachment{
+		FileName: doc.FileName,
+		MimeType: doc.MimeType,
+		Size:     int64(len(data)),
+	}
+	attachment.Text, attachment.Truncated = truncateMiddle(text, maxAttachmentTextLength)
+	if attachment.Truncated {
+		err = s.telegram.SendMessage(ctx, u.ChatID(),
+			fmt.Sprintf("%s is too long, only its beginning and end are sent", doc.FileName))
+		if err != nil {
+			return err
+		}
+	}
+	return sm.Execute(ctx, &ChatMessage{
+		Message:     u.Message.Caption,
+		MessageID:   u.Message.ID,
+		Attachments: []domain.Attachment{attachment},
+	})
+}
+
+// truncateMiddle keeps the beginning and the end of the text,
+// e.g. the setup and the failure of the log.
+func truncateMiddle(text string, limit int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, false
+	}
+	head := limit / 2
+	tail := limit - head
+	return fmt.Sprintf("%s\n… %d characters truncated …\n%s",
+		string(runes[:head]), len(runes)-limit, string(runes[len(runes)-tail:])), true
+}
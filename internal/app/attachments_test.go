@@ -0,0 +1,29 @@
+package app
+
+import "testing"
+
+func TestTruncateMiddle(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		limit     int
+		expected  string
+		truncated bool
+	}{
+		{"empty", "", 10, "", false},
+		{"shorter than limit", "hello", 10, "hello", false},
+		{"exactly the limit", "0123456789", 10, "0123456789", false},
+		{"even limit", "0123456789", 4, "01\n… 6 characters truncated …\n89", true},
+		{"odd limit keeps more of the end", "0123456789", 5, "01\n… 5 characters truncated …\n789", true},
+		{"zero limit", "abc", 0, "\n… 3 characters truncated …\n", true},
+		{"counts runes, not bytes", "привет, мир", 6, "при\n… 5 characters truncated …\nмир", true},
+		{"multibyte within limit", "привет", 6, "привет", false},
+	}
+	for _, tt := range tests {
+		text, truncated := truncateMiddle(tt.text, tt.limit)
+		if text != tt.expected || truncated != tt.truncated {
+			t.Errorf("%s: truncateMiddle(%q, %d) = %q, %t, expected %q, %t",
+				tt.name, tt.text, tt.limit, text, truncated, tt.expected, tt.truncated)
+		}
+	}
+}
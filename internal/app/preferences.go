@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+	"github.com/xenking/managed-tg-gpt-chat/pkg/tgrouter"
+)
+
+const (
+	modelCallbackPrefix   = "model:"
+	personaCallbackPrefix = "persona:"
+)
+
+// handleModelCommand offers the configured models, the choice applies to the next requests.
+func (s *Service) handleModelCommand(ctx context.Context, u *tgrouter.Update) error {
+	current := s.chatOptions(u.ChatID()).Model
+	var buttons []domain.KeyboardButton
+	for _, model := range s.cfg.Models {
+		buttons = append(buttons, domain.KeyboardButton{
+			Text:         markCurrent(model, current),
+			CallbackData: modelCallbackPrefix + model,
+		})
+	}
+	_, err := s.telegram.SendMessageHTMLWithInlineKeyboard(ctx, u.ChatID(),
+		fmt.Sprintf("Current model: <b>%s</b>\nChoose the model for the next requests:", html.EscapeString(current)),
+		buttons)
+	return err
+}
+
+// handlePersonaCommand offers the configured personas, the choice applies to the next requests.
+func (s *Service) handlePersonaCommand(ctx context.Context, u *tgrouter.Update) error {
+	current := s.chatOptions(u.ChatID()).Persona
+	var (
+		sb      strings.Builder
+		buttons []domain.KeyboardButton
+	)
+	fmt.Fprintf(&sb, "Current persona: <b>%s</b>\n", html.EscapeString(current))
+	for _, persona := range s.cfg.Personas {
+		fmt.Fprintf(&sb, "\n<b>%s</b> — %s", html.EscapeString(persona.Name), html.EscapeString(persona.Description))
+		buttons = append(buttons, domain.KeyboardButton{
+			Text:         markCurrent(persona.Name, current),
+			CallbackData: personaCallbackPrefix + persona.Name,
+		})
+	}
+	sb.WriteString("\n\nChoose the persona for the next requests:")
+	_, err := s.telegram.SendMessageHTMLWithInlineKeyboard(ctx, u.ChatID(), sb.String(), buttons)
+	return err
+}
+
+func (s *Service) handlePreferenceCallback(ctx context.Context, u *tgrouter.Update) error {
+	q := u.CallbackQuery
+	prefs, _ := s.preferences.Get(q.Message.Chat.ID)
+	var text string
+	if model, ok := strings.CutPrefix(q.Data, modelCallbackPrefix); ok {
+		if !slices.Contains(s.cfg.Models, model) {
+			return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The model is no longer available", true)
+		}
+		prefs.Model = model
+		text = "Model for the next requests: <b>%s</b>"
+	} else if persona, ok := strings.CutPrefix(q.Data, personaCallbackPrefix); ok {
+		if _, ok := domain.FindPersona(s.cfg.Personas, persona); !ok {
+			return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The persona is no longer available", true)
+		}
+		prefs.Persona = persona
+		text = "Persona for the next requests: <b>%s</b>"
+	} else {
+		return fmt.Errorf("unknown callback data %s", q.Data)
+	}
+	err := s.preferences.Set(q.Message.Chat.ID, prefs)
+	if err != nil {
+		return err
+	}
+
+	choice := q.Data[strings.IndexByte(q.Data, ':')+1:]
+	err = s.telegram.AnswerCallbackQuery(ctx, q.ID, "Saved: "+choice, false)
+	if err != nil {
+		return err
+	}
+	return s.telegram.EditMessageHTML(ctx, q.Message.Chat.ID, domain.TelegramMessage{
+		ID:   q.Message.ID,
+		Text: fmt.Sprintf(text, html.EscapeString(choice)),
+	})
+}
+
+// handleChatPersonaCommand sets the default persona of the user's chat
+// by "/persona <name>" replied to the request post in the audit channel group.
+func (s *Service) handleChatPersonaCommand(ctx context.Context, u *tgrouter.Update) error {
+	reply := u.Message.ReplyToMessage
+	_, name, _ := strings.Cut(u.Message.Text, " ")
+	name = strings.TrimSpace(name)
+	if reply == nil || reply.ForwardOrigin == nil || name == "" {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			"Reply to the request with <code>/persona name</code> to set the default persona of the chat")
+	}
+	allowed, err := s.approvers.IsApprover(ctx, s.cfg.AuditLogChannelID, u.Message.From.ID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, "You are not allowed to set the persona")
+	}
+	if _, ok := domain.FindPersona(s.cfg.Personas, name); !ok {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			fmt.Sprintf("Unknown persona <b>%s</b>", html.EscapeString(name)))
+	}
+	chatID := getUserFromMessageEntities(reply)
+	if chatID == -1 {
+		return fmt.Errorf("user not found in message %d", reply.ID)
+	}
+
+	prefs, _ := s.preferences.Get(chatID)
+	prefs.DefaultPersona = name
+	err = s.preferences.Set(chatID, prefs)
+	if err != nil {
+		return err
+	}
+	return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+		fmt.Sprintf("Default persona of the chat: <b>%s</b>", html.EscapeString(name)))
+}
+
+// chatOptions returns the options chosen by the user with the chat and configured defaults.
+func (s *Service) chatOptions(chatID int64) domain.ChatOptions {
+	prefs, _ := s.preferences.Get(chatID)
+	opts := domain.ChatOptions{
+		Model:   prefs.Model,
+		Persona: prefs.Persona,
+	}
+	if opts.Model == "" {
+		opts.Model = s.cfg.DefaultModel
+	}
+	if opts.Persona == "" {
+		opts.Persona = prefs.DefaultPersona
+	}
+	if opts.Persona == "" {
+		opts.Persona = s.cfg.DefaultPersona
+	}
+	opts.Context = s.cfg.ContextPolicy
+	if persona, ok := domain.FindPersona(s.cfg.Personas, opts.Persona); ok {
+		opts.SystemPrompt = persona.SystemPrompt
+		if persona.Context.Strategy != "" {
+			opts.Context = persona.Context
+		}
+	} else {
+		opts.Persona = ""
+	}
+	return opts
+}
+
+func markCurrent(option, current string) string {
+	if option == current {
+		return "✓ " + option
+	}
+	return option
+}
@@ -2,18 +2,36 @@ package app
 
 import (
 	"context"
+	"errors"
 
 	"github.com/google/uuid"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 
-	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
 	"github.com/xenking/managed-tg-gpt-chat/internal/app/workflows"
 	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+	"github.com/xenking/managed-tg-gpt-chat/pkg/echotron"
 )
 
 type ChatMessage struct {
 	Message   string
 	MessageID int
+	Images    []domain.ChatImage
+	// Attachments hold the text extracted from the documents.
+	Attachments []domain.Attachment
+}
+
+// newChatMessage takes the request from the text or the caption of the photo.
+func newChatMessage(msg *echotron.Message) *ChatMessage {
+	chatMsg := &ChatMessage{Message: msg.Text, MessageID: msg.ID}
+	if len(msg.Photo) > 0 {
+		// Sizes are sorted from the smallest, the model gets the largest one
+		chatMsg.Message = msg.Caption
+		chatMsg.Images = []domain.ChatImage{{FileID: msg.Photo[len(msg.Photo)-1].FileID}}
+	}
+	return chatMsg
 }
 
 // PrivateChatStateMachine maintains current state and shared dependencies.
@@ -26,6 +44,11 @@ type PrivateChatStateMachine struct {
 	WorkflowActivityID string
 	WorkflowID         string
 	WorkflowRunID      string
+	// InConversation is set once the first request is approved
+	// and the workflow accepts follow-up questions.
+	InConversation bool
+	// listening is set after /ask until the request is sent.
+	listening bool
 }
 
 func (s *Service) NewPrivateChatStateMachine(chatID int64, userName string) *PrivateChatStateMachine {
@@ -44,63 +67,180 @@ func (sm *PrivateChatStateMachine) StateNoop(ctx context.Context, msg *ChatMessa
 }
 
 func (sm *PrivateChatStateMachine) StateNewDialog(ctx context.Context, msg *ChatMessage) (domain.StateFunc[*ChatMessage], error) {
+	if sm.InConversation {
+		err := sm.endConversation(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sm.Reset()
+	}
 	err := sm.telegram.SendMessage(ctx, sm.chatID, "Please enter your request")
 	if err != nil {
 		return nil, err
 	}
+	sm.listening = true
 	return sm.StateListenRequest, nil
 }
 
 func (sm *PrivateChatStateMachine) StateListenRequest(ctx context.Context, msg *ChatMessage) (domain.StateFunc[*ChatMessage], error) {
+	if sm.draining.Load() {
+		err := sm.telegram.SendMessage(ctx, sm.chatID, "The bot is being upgraded, please send the request again in a few minutes")
+		return sm.StateListenRequest, err
+	}
+	approvalPolicy := sm.cfg.ApprovalPolicy
+	approvalPolicy.Quorum = domain.MatchQuorumPolicy(sm.cfg.QuorumPolicies, msg.Message)
 	workflow, err := sm.temporal.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		// Approval callback data references the workflow by its UUID
+		ID:        uuid.New().String(),
 		TaskQueue: domain.ChatRequestsQueue,
+		TypedSearchAttributes: temporal.NewSearchAttributes(
+			workflows.ChatIDSearchAttribute.ValueSet(sm.chatID),
+			workflows.ChatUserNameSearchAttribute.ValueSet(sm.userName),
+			workflows.InConversationSearchAttribute.ValueSet(false),
+			workflows.SessionVersionSearchAttribute.ValueSet(workflows.SessionVersion),
+		),
 	}, workflows.ChatGTPSession, workflows.ChatGPTSessionInput{
 		ChatID:             sm.chatID,
 		ChatUserName:       sm.userName,
 		Request:            msg.Message,
+		Images:             msg.Images,
+		Attachments:        msg.Attachments,
+		ChatOptions:        sm.chatOptions(sm.chatID),
+		VisionModels:       sm.cfg.VisionModels,
 		WorkflowActivityID: sm.WorkflowActivityID,
 		AuditLogChannelID:  sm.cfg.AuditLogChannelID,
+		IdleTimeout:        sm.cfg.ConversationIdleTimeout,
+		ApprovalPolicy:     approvalPolicy,
+		QuorumPolicies:     sm.cfg.QuorumPolicies,
+		QuotaPolicy:        sm.cfg.QuotaPolicy,
+		ApprovalRules:      sm.cfg.ApprovalRules,
+		ModerationPolicy:   sm.cfg.ModerationPolicy,
 	})
 	if err != nil {
 		return nil, err
 	}
 	sm.WorkflowID = workflow.GetID()
 	sm.WorkflowRunID = workflow.GetRunID()
-	err = sm.telegram.SendMessage(ctx, sm.chatID, "Waiting for approval...")
-	return sm.StateWaitForApprove, err
+	sm.listening = false
+	// The workflow tells the user if the request waits for approvers
+	return sm.StateWaitForApprove, nil
 }
 
 func (sm *PrivateChatStateMachine) StateWaitForApprove(ctx context.Context, msg *ChatMessage) (domain.StateFunc[*ChatMessage], error) {
-	err := sm.telegram.SendMessage(ctx, sm.chatID, "Waiting for approval...\nYou can cancel the request by /cancel")
-	return sm.StateWaitForApprove, err
+	// The request could be resolved by the approval policy without an approver callback
+	desc, err := sm.temporal.DescribeWorkflowExecution(ctx, sm.WorkflowID, sm.WorkflowRunID)
+	if err != nil {
+		return nil, err
+	}
+	if desc.GetWorkflowExecutionInfo().GetStatus() != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		sm.Reset()
+		err = sm.telegram.SendMessage(ctx, sm.chatID, "Your request is closed. Start a new one by /ask")
+		return sm.StateNoop, err
+	}
+	// The approval post may not exist yet, the session status tells whether the request is decided
+	status, err := sm.querySessionStatus(ctx, sm.WorkflowID, sm.WorkflowRunID)
+	if err != nil {
+		return nil, err
+	}
+	switch status.ApprovalStatus {
+	case domain.RequestStatusApproved:
+		sm.InConversation = true
+		return sm.StateContinueConversation(ctx, msg)
+	case domain.RequestStatusPending:
+		err = sm.telegram.SendMessage(ctx, sm.chatID, "Waiting for approval...\nYou can cancel the request by /cancel")
+		return sm.StateWaitForApprove, err
+	}
+	// The rejected session is about to finish
+	sm.Reset()
+	err = sm.telegram.SendMessage(ctx, sm.chatID, "Your request is closed. Start a new one by /ask")
+	return sm.StateNoop, err
 }
 
 func (sm *PrivateChatStateMachine) StateContinueConversation(ctx context.Context, msg *ChatMessage) (domain.StateFunc[*ChatMessage], error) {
-	// TODO: Continue conversation in new workflow (or old one)
-	return sm.StateListenRequest, nil
+	if sm.draining.Load() {
+		err := sm.endConversation(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sm.Reset()
+		err = sm.telegram.SendMessage(ctx, sm.chatID, upgradeNotice)
+		return sm.StateNoop, err
+	}
+	err := sm.temporal.SignalWorkflow(ctx, sm.WorkflowID, sm.WorkflowRunID, workflows.ContinueConversationSignal,
+		workflows.ContinueConversationInput{
+			Request:     msg.Message,
+			Images:      msg.Images,
+			Attachments: msg.Attachments,
+		})
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		// The conversation is already closed (e.g. by idle timeout) - start a new one
+		sm.Reset()
+		return sm.StateListenRequest(ctx, msg)
+	}
+	// The workflow tells the user if the follow-up waits for approvers
+	return sm.StateContinueConversation, err
 }
 
 func (sm *PrivateChatStateMachine) StateCancel(ctx context.Context, msg *ChatMessage) (domain.StateFunc[*ChatMessage], error) {
 	defer sm.Reset()
-	err := sm.temporal.CompleteActivityByID(ctx,
-		"default",
-		sm.WorkflowID, sm.WorkflowRunID,
-		sm.WorkflowActivityID,
-		activities.GetRequestApprovalResponse{
-			MessageID: msg.MessageID,
-			Message:   "Request canceled",
-			Status:    domain.RequestStatusCanceled,
-		},
-		nil)
+	if sm.InConversation {
+		err := sm.endConversation(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = sm.telegram.SendMessage(ctx, sm.chatID, "Conversation finished")
+		return sm.StateNoop, err
+	}
+	// The request could be resolved automatically or by approvers before the user canceled it
+	status, err := sm.querySessionStatus(ctx, sm.WorkflowID, sm.WorkflowRunID)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		err = sm.telegram.SendMessage(ctx, sm.chatID, "Your request is closed. Start a new one by /ask")
+		return sm.StateNoop, err
+	}
 	if err != nil {
 		return nil, err
 	}
-	err = sm.telegram.SendMessage(ctx, sm.chatID, "Request canceled")
+	switch status.ApprovalStatus {
+	case domain.RequestStatusPending:
+		// The workflow tells the user that the request is canceled
+		err = sm.temporal.SignalWorkflow(ctx, sm.WorkflowID, sm.WorkflowRunID, workflows.CancelRequestSignal, nil)
+		if errors.As(err, &notFound) {
+			err = nil
+		}
+		return sm.StateNoop, err
+	case domain.RequestStatusApproved:
+		err = sm.endConversation(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = sm.telegram.SendMessage(ctx, sm.chatID, "Conversation finished")
+		return sm.StateNoop, err
+	}
+	err = sm.telegram.SendMessage(ctx, sm.chatID, "Your request is closed. Start a new one by /ask")
 	return sm.StateNoop, err
 }
 
+// endConversation asks the running workflow to stop waiting for follow-ups.
+func (sm *PrivateChatStateMachine) endConversation(ctx context.Context) error {
+	err := sm.temporal.SignalWorkflow(ctx, sm.WorkflowID, sm.WorkflowRunID, workflows.EndConversationSignal, nil)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
 func (sm *PrivateChatStateMachine) Reset() {
 	sm.WorkflowRunID = ""
 	sm.WorkflowID = ""
 	sm.WorkflowActivityID = uuid.New().String()
+	sm.InConversation = false
+	sm.listening = false
+}
+
+// AcceptsRequest tells whether the next message is sent as the request or the follow-up question.
+func (sm *PrivateChatStateMachine) AcceptsRequest() bool {
+	return sm.listening || sm.InConversation
 }
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+	"github.com/xenking/managed-tg-gpt-chat/pkg/tgrouter"
+)
+
+const quotaCommandUsage = "Reply to the request with <code>/quota daily|monthly [tokens] [$cost]</code>, " +
+	"e.g. <code>/quota daily 500000 $2.5</code>, zero removes the limit"
+
+// handleQuotaCommand replaces the daily or monthly budget of the user
+// by "/quota daily|monthly [tokens] [$cost]" replied to the request post in the audit channel group.
+func (s *Service) handleQuotaCommand(ctx context.Context, u *tgrouter.Update) error {
+	reply := u.Message.ReplyToMessage
+	args := strings.Fields(u.Message.Text)[1:]
+	if reply == nil || reply.ForwardOrigin == nil || len(args) < 2 {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, quotaCommandUsage)
+	}
+	allowed, err := s.approvers.IsApprover(ctx, s.cfg.AuditLogChannelID, u.Message.From.ID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, "You are not allowed to change quotas")
+	}
+	budget, err := parseBudget(args[1:])
+	if err != nil {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			fmt.Sprintf("%s\n%s", html.EscapeString(err.Error()), quotaCommandUsage))
+	}
+	chatID := getUserFromMessageEntities(reply)
+	if chatID == -1 {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			"Unable to find the user of the request, reply to the request post")
+	}
+
+	quota, ok := s.quotas.Get(chatID)
+	if !ok {
+		quota = domain.UserQuota{
+			Daily:   s.cfg.QuotaPolicy.UserDaily,
+			Monthly: s.cfg.QuotaPolicy.UserMonthly,
+		}
+	}
+	switch args[0] {
+	case "daily":
+		quota.Daily = budget
+	case "monthly":
+		quota.Monthly = budget
+	default:
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, quotaCommandUsage)
+	}
+	err = s.quotas.Set(chatID, quota)
+	if err != nil {
+		return err
+	}
+	return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+		fmt.Sprintf("Quota of the user: daily %s, monthly %s", formatBudget(quota.Daily), formatBudget(quota.Monthly)))
+}
+
+// parseBudget parses the tokens and the cost prefixed with $, both must not be negative.
+func parseBudget(args []string) (domain.Budget, error) {
+	var budget domain.Budget
+	for _, arg := range args {
+		var valid bool
+		if cost, ok := strings.CutPrefix(arg, "$"); ok {
+			var err error
+			budget.Cost, err = strconv.ParseFloat(cost, 64)
+			// NaN fails the comparison as well
+			valid = err == nil && budget.Cost >= 0 && !math.IsInf(budget.Cost, 1)
+		} else {
+			var err error
+			budget.Tokens, err = strconv.Atoi(arg)
+			valid = err == nil && budget.Tokens >= 0
+		}
+		if !valid {
+			return domain.Budget{}, fmt.Errorf("invalid budget %s, tokens and cost must be non-negative numbers", arg)
+		}
+	}
+	return budget, nil
+}
+
+func formatBudget(b domain.Budget) string {
+	var limits []string
+	if b.Tokens > 0 {
+		limits = append(limits, fmt.Sprintf("%d tokens", b.Tokens))
+	}
+	if b.Cost > 0 {
+		limits = append(limits, fmt.Sprintf("$%.2f", b.Cost))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, " and ")
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected domain.Budget
+		wantErr  bool
+	}{
+		{"tokens", []string{"500000"}, domain.Budget{Tokens: 500000}, false},
+		{"cost", []string{"$2.5"}, domain.Budget{Cost: 2.5}, false},
+		{"tokens and cost", []string{"1000", "$1"}, domain.Budget{Tokens: 1000, Cost: 1}, false},
+		{"zero removes the limit", []string{"0", "$0"}, domain.Budget{}, false},
+		{"negative tokens", []string{"-1"}, domain.Budget{}, true},
+		{"negative cost", []string{"$-0.5"}, domain.Budget{}, true},
+		{"infinite cost", []string{"$Inf"}, domain.Budget{}, true},
+		{"NaN cost", []string{"$NaN"}, domain.Budget{}, true},
+		{"not a number", []string{"many"}, domain.Budget{}, true},
+	}
+	for _, tt := range tests {
+		budget, err := parseBudget(tt.args)
+		if (err != nil) != tt.wantErr || budget != tt.expected {
+			t.Errorf("%s: parseBudget(%q) = %+v, %v, expected %+v, error %t",
+				tt.name, tt.args, budget, err, tt.expected, tt.wantErr)
+		}
+	}
+}
@@ -2,13 +2,24 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"log/slog"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
-	"github.com/google/uuid"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
 
 	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
 	"github.com/xenking/managed-tg-gpt-chat/internal/app/workflows"
@@ -21,32 +32,74 @@ type Config struct {
 	WhitelistedUsers       []int64
 	AuditLogChannelID      int64
 	AuditLogChannelGroupID int64
+	// ConversationIdleTimeout closes the conversation if the user stays silent.
+	ConversationIdleTimeout time.Duration
+	ApprovalPolicy          domain.ApprovalPolicy
+	// QuorumPolicies are request categories which need N-of-M approvals.
+	QuorumPolicies []domain.QuorumPolicy
+	// Models are offered to users by /model, DefaultModel is used until the user picks one.
+	Models       []string
+	DefaultModel string
+	// VisionModels accept images sent by users.
+	VisionModels []string
+	// Personas are offered to users by /persona, DefaultPersona is used until the user
+	// or an admin picks one for the chat.
+	Personas       []domain.Persona
+	DefaultPersona string
+	// ContextPolicy keeps conversations within the context window unless the persona has its own policy.
+	ContextPolicy domain.ContextPolicy
+	// QuotaPolicy limits the spending, admins raise the user budgets by /quota.
+	QuotaPolicy domain.QuotaPolicy
+	// ApprovalRules resolve matching requests before they reach approvers.
+	ApprovalRules []domain.ApprovalRule
+	// ModerationPolicy redacts sensitive data for the audit channel and blocks harmful requests.
+	ModerationPolicy domain.ModerationPolicy
 }
 
 type Service struct {
-	telegram     domain.TelegramClient
-	temporal     domain.TemporalClient
-	tokenStorage domain.ActivitiesTokenStorage
-	logger       *slog.Logger
-	cfg          Config
-	dialogs      map[int64]*PrivateChatStateMachine
-	mu           sync.RWMutex
+	telegram    domain.TelegramClient
+	temporal    domain.TemporalClient
+	callbacks   domain.ApprovalCallbackCodec
+	approvers   domain.ApproverRegistry
+	preferences domain.PreferencesStorage
+	usage       domain.UsageStorage
+	quotas      domain.QuotaStorage
+	speech      domain.SpeechToText
+	documents   domain.TextExtractor
+	logger      *slog.Logger
+	cfg         Config
+	dialogs     map[int64]*PrivateChatStateMachine
+	mu          sync.RWMutex
+	// draining refuses new requests before the upgrade, see DrainSessions.
+	draining atomic.Bool
 }
 
 func NewService(
 	telegramClient domain.TelegramClient,
 	temporalClient domain.TemporalClient,
-	tokenStorage domain.ActivitiesTokenStorage,
+	callbacks domain.ApprovalCallbackCodec,
+	approvers domain.ApproverRegistry,
+	preferences domain.PreferencesStorage,
+	usage domain.UsageStorage,
+	quotas domain.QuotaStorage,
+	speech domain.SpeechToText,
+	documents domain.TextExtractor,
 	logger *slog.Logger,
 	cfg Config,
 ) *Service {
 	return &Service{
-		telegram:     telegramClient,
-		temporal:     temporalClient,
-		tokenStorage: tokenStorage,
-		dialogs:      make(map[int64]*PrivateChatStateMachine),
-		logger:       logger,
-		cfg:          cfg,
+		telegram:    telegramClient,
+		temporal:    temporalClient,
+		callbacks:   callbacks,
+		approvers:   approvers,
+		preferences: preferences,
+		usage:       usage,
+		quotas:      quotas,
+		speech:      speech,
+		documents:   documents,
+		dialogs:     make(map[int64]*PrivateChatStateMachine),
+		logger:      logger,
+		cfg:         cfg,
 	}
 }
 
@@ -55,7 +108,15 @@ func (s *Service) PrivateChatRoutes() domain.TelegramRoute {
 		tgrouter.NewCommandRoute("/start", nil, tgrouter.HandlerFunc(s.handleStartCommand)),
 		tgrouter.NewCommandRoute("/ask", nil, tgrouter.HandlerFunc(s.handleStateMachineCreate)),
 		tgrouter.NewCommandRoute("/cancel", nil, tgrouter.HandlerFunc(s.handleStateMachineCancel)),
-		tgrouter.NewMessageRoute(nil, tgrouter.HandlerFunc(s.handleStateMachine)),
+		tgrouter.NewCommandRoute("/status", nil, tgrouter.HandlerFunc(s.handleStatusCommand)),
+		tgrouter.NewCommandRoute("/usage", nil, tgrouter.HandlerFunc(s.handleUsageCommand)),
+		tgrouter.NewCommandRoute("/model", nil, tgrouter.HandlerFunc(s.handleModelCommand)),
+		tgrouter.NewCommandRoute("/persona", nil, tgrouter.HandlerFunc(s.handlePersonaCommand)),
+		tgrouter.NewRoute(hasCallbackPrefix("voice:"), tgrouter.HandlerFunc(s.handleVoiceCallback)),
+		tgrouter.NewRoute(tgrouter.IsCallbackQuery(), tgrouter.HandlerFunc(s.handlePreferenceCallback)),
+		tgrouter.NewMessageRoute(tgrouter.HasVoice(), tgrouter.HandlerFunc(s.handleVoiceMessage)),
+		tgrouter.NewMessageRoute(tgrouter.HasDocument(), tgrouter.HandlerFunc(s.handleDocumentMessage)),
+		tgrouter.NewMessageRoute(tgrouter.Or(tgrouter.HasText(), tgrouter.HasPhoto()), tgrouter.HandlerFunc(s.handleStateMachine)),
 	)
 }
 
@@ -66,6 +127,9 @@ func (s *Service) GroupRoutes() domain.TelegramRoute {
 				return s.handleCompleteActivity(ctx, u.CallbackQuery)
 			}),
 		),
+		tgrouter.NewCommandRoute("/approve /reject", tgrouter.IsSuperGroup(), tgrouter.HandlerFunc(s.handleApprovalReply)),
+		tgrouter.NewCommandRoute("/persona", tgrouter.IsSuperGroup(), tgrouter.HandlerFunc(s.handleChatPersonaCommand)),
+		tgrouter.NewCommandRoute("/quota", tgrouter.IsSuperGroup(), tgrouter.HandlerFunc(s.handleQuotaCommand)),
 		tgrouter.NewRoute(tgrouter.And(tgrouter.IsSuperGroup(), tgrouter.IsForwardOriginType("channel")), tgrouter.HandlerFunc(
 			func(ctx context.Context, u *tgrouter.Update) error {
 				return s.handleForwarderGroupMessage(ctx, u)
@@ -81,52 +145,235 @@ func (s *Service) GroupRoutes() domain.TelegramRoute {
 }
 
 func (s *Service) handleCompleteActivity(ctx context.Context, q *echotron.CallbackQuery) error {
-	activityToken, status, err := s.parseCallbackData(q.Data)
+	callback, err := s.callbacks.Decode(q.Data)
+	if err != nil && callback.WorkflowID == "" {
+		s.logger.WarnContext(ctx, "forged approval callback", slog.String("error", err.Error()),
+			slog.Int64("user_id", q.From.ID), slog.String("user_name", q.From.UserName))
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "Invalid request", true)
+	}
 	if err != nil {
-		_ = s.telegram.EditMessageHTML(ctx, q.Message.Chat.ID, domain.TelegramMessage{
-			Text:     fmt.Sprintf("%s\n\nStatus: <b>%s</b>", q.Message.Text, domain.RequestStatusCanceled),
-			ID:       q.Message.ID,
-			Entities: domain.ParseTelegramMessageEntities(q.Message.Entities),
-		})
+		// The approval deadline resolves the request and closes the post
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The request has expired", true)
+	}
+	if callback.Quorum {
+		return s.handleApprovalVote(ctx, q, callback)
+	}
+
+	allowed, authErr := s.approvers.IsApprover(ctx, q.Message.Chat.ID, q.From.ID)
+	if authErr != nil {
+		return authErr
+	}
+	if !allowed {
+		s.logger.WarnContext(ctx, "unauthorized approval callback",
+			slog.Int64("user_id", q.From.ID), slog.String("user_name", q.From.UserName))
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "You are not allowed to decide on requests", true)
+	}
+
+	status := callback.Status
+	err = s.temporal.CompleteActivityByID(ctx, client.DefaultNamespace, callback.WorkflowID, "", callback.ActivityID,
+		activities.GetRequestApprovalResponse{
+			MessageID:     q.Message.ID,
+			Message:       fmt.Sprintf("Request %s", status),
+			Status:        status,
+			DecidedBy:     q.From.ID,
+			DecidedByName: q.From.UserName,
+		}, nil)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return s.closeDecidedApproval(ctx, q, callback.WorkflowID)
+	}
+	if err != nil {
+		// The request is still pending, the approver may press the button again
+		_ = s.telegram.AnswerCallbackQuery(ctx, q.ID, "Unable to record the decision, try again", true)
 		return err
 	}
-	err = s.temporal.CompleteActivity(ctx, activityToken, activities.GetRequestApprovalResponse{
-		MessageID: q.Message.ID,
-		Message:   fmt.Sprintf("Request %s", status),
-		Status:    status,
-	}, nil)
+	s.logger.InfoContext(ctx, "approval decision", slog.String("status", string(status)),
+		slog.Int64("user_id", q.From.ID), slog.String("user_name", q.From.UserName))
+	err = s.telegram.AnswerCallbackQuery(ctx, q.ID, fmt.Sprintf("Request %s", status), false)
 	if err != nil {
 		return err
 	}
+	text := fmt.Sprintf("%s\n\nStatus: <b>%s</b> by @%s",
+		html.EscapeString(q.Message.Text), status, html.EscapeString(q.From.UserName))
 	err = s.telegram.EditMessageHTML(ctx, q.Message.Chat.ID, domain.TelegramMessage{
-		Text:     fmt.Sprintf("%s\n\nStatus: <b>%s</b>", q.Message.Text, status),
+		Text:     text,
 		ID:       q.Message.ID,
 		Entities: domain.ParseTelegramMessageEntities(q.Message.Entities),
 	})
-	// Reset the state machine if the request was rejected
-	if status == domain.RequestStatusRejected {
-		chatID := getUserFromMessageEntities(q.Message)
-		sm := s.getDialogSM(chatID)
+	chatID := getUserFromMessageEntities(q.Message)
+	sm := s.getDialogSM(chatID)
+	if sm == nil {
+		return err
+	}
+	switch status {
+	case domain.RequestStatusApproved:
+		// Follow-up messages continue the approved conversation
+		sm.InConversation = true
+		sm.Set(sm.StateContinueConversation)
+	case domain.RequestStatusRejected:
+		// Rejected follow-ups keep the conversation going
+		if sm.InConversation {
+			break
+		}
+		// Reset the state machine if the request was rejected
 		sm.Reset()
 		sm.Set(sm.StateNoop)
 	}
 	return err
 }
 
-func (s *Service) parseCallbackData(data string) ([]byte, domain.RequestStatus, error) {
-	parts := strings.Split(data, ":")
-	uid := uuid.MustParse(parts[0])
-	activityToken, ok := s.tokenStorage.Pop(uid)
-	if !ok {
-		return nil, domain.RequestStatusRejected, fmt.Errorf("activity token not found for callback ID %s", parts[0])
+// closeDecidedApproval answers the callback of the approval which is no longer pending:
+// the post is marked canceled if the session was canceled or terminated,
+// otherwise the callback is replayed and the request is already decided.
+func (s *Service) closeDecidedApproval(ctx context.Context, q *echotron.CallbackQuery, workflowID string) error {
+	desc, err := s.temporal.DescribeWorkflowExecution(ctx, workflowID, "")
+	if err != nil {
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The request is already closed", true)
 	}
-	switch parts[1] {
-	case "a":
-		return activityToken, domain.RequestStatusApproved, nil
-	case "r":
-		return activityToken, domain.RequestStatusRejected, nil
+	switch desc.GetWorkflowExecutionInfo().GetStatus() {
+	case enums.WORKFLOW_EXECUTION_STATUS_CANCELED, enums.WORKFLOW_EXECUTION_STATUS_TERMINATED:
+		err = s.telegram.AnswerCallbackQuery(ctx, q.ID, "The request was canceled", true)
+		if err != nil {
+			return err
+		}
+		return s.telegram.EditMessageHTML(ctx, q.Message.Chat.ID, domain.TelegramMessage{
+			Text:     fmt.Sprintf("%s\n\nStatus: <b>%s</b>", html.EscapeString(q.Message.Text), domain.RequestStatusCanceled),
+			ID:       q.Message.ID,
+			Entities: domain.ParseTelegramMessageEntities(q.Message.Entities),
+		})
 	}
-	return nil, domain.RequestStatusRejected, fmt.Errorf("unknown callback data %s", parts[1])
+	return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The request is already closed", true)
+}
+
+// handleApprovalReply decides on the request by a reply to its post in the audit channel group:
+// "/reject <reason>" forwards the reason to the user,
+// "/approve <edited request>" sends the edited request to Chat GPT instead of the original one.
+func (s *Service) handleApprovalReply(ctx context.Context, u *tgrouter.Update) error {
+	reply := u.Message.ReplyToMessage
+	if reply == nil || reply.ForwardOrigin == nil {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			"Reply to the request with <code>/reject reason</code> or <code>/approve edited request</code>")
+	}
+	allowed, err := s.approvers.IsApprover(ctx, s.cfg.AuditLogChannelID, u.Message.From.ID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, "You are not allowed to decide on requests")
+	}
+
+	chatID := getUserFromMessageEntities(reply)
+	sm := s.getDialogSM(chatID)
+	if sm == nil || sm.WorkflowID == "" {
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, "The request is already closed")
+	}
+	workflowID, runID, pending, err := s.queryPendingApproval(ctx, sm.WorkflowID, sm.WorkflowRunID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !pending.Pending:
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID, "The request is already closed")
+	case pending.Quorum != nil:
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			"The request is decided by the quorum, vote with the buttons")
+	case !slices.Contains(pending.MessageIDs, reply.ForwardOrigin.MessageID):
+		return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+			"The request was sent to approvers again, reply to the latest post")
+	}
+
+	command, argument := u.Message.Text, ""
+	if i := strings.IndexFunc(command, unicode.IsSpace); i >= 0 {
+		command, argument = command[:i], command[i:]
+	}
+	decision := workflows.ApprovalDecisionInput{
+		ChannelMessageID: reply.ForwardOrigin.MessageID,
+		DecidedBy:        u.Message.From.ID,
+		DecidedByName:    u.Message.From.UserName,
+	}
+	argument = strings.TrimSpace(argument)
+	if strings.HasPrefix(command, "/approve") {
+		if argument != "" && pending.Redacted {
+			return s.telegram.ReplyToMessageHTML(ctx, u.ChatID(), u.Message.ID,
+				"The request is redacted and can't be edited, approve it as is or reject it")
+		}
+		decision.Status = domain.RequestStatusApproved
+		decision.EditedRequest = argument
+	} else {
+		decision.Status = domain.RequestStatusRejected
+		decision.Reason = argument
+	}
+
+	// The workflow closes the post once the decision is recorded, the state machine
+	// follows the session status on the next message of the user
+	return s.temporal.SignalWorkflow(ctx, workflowID, runID, workflows.ApprovalDecisionSignal, decision)
+}
+
+// queryPendingApproval returns the approval of the session or of its follow-up in progress
+// with the workflow handling it. The approval is not pending if the workflow has none.
+func (s *Service) queryPendingApproval(ctx context.Context, workflowID, runID string) (string, string, workflows.PendingApproval, error) {
+	var pending workflows.PendingApproval
+	status, err := s.querySessionStatus(ctx, workflowID, runID)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return workflowID, runID, pending, nil
+	}
+	if err != nil {
+		return workflowID, runID, pending, err
+	}
+	if status.TurnWorkflowID != "" {
+		workflowID, runID = status.TurnWorkflowID, ""
+	}
+	value, err := s.temporal.QueryWorkflow(ctx, workflowID, runID, workflows.PendingApprovalQuery)
+	var queryFailed *serviceerror.QueryFailed
+	if errors.As(err, &notFound) || errors.As(err, &queryFailed) {
+		// Closed or resolved without approvers
+		return workflowID, runID, pending, nil
+	}
+	if err != nil {
+		return workflowID, runID, pending, err
+	}
+	err = value.Get(&pending)
+	return workflowID, runID, pending, err
+}
+
+// handleApprovalVote records the vote in the workflow, which decides the request once the quorum is reached.
+// Named approvers of the quorum are authorized by the policy itself.
+func (s *Service) handleApprovalVote(ctx context.Context, q *echotron.CallbackQuery, callback domain.ApprovalCallback) error {
+	value, err := s.temporal.QueryWorkflow(ctx, callback.WorkflowID, "", workflows.PendingApprovalQuery)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The request is already closed", true)
+	}
+	if err != nil {
+		_ = s.telegram.AnswerCallbackQuery(ctx, q.ID, "Unable to record the vote, try again", true)
+		return err
+	}
+	var pending workflows.PendingApproval
+	err = value.Get(&pending)
+	if err != nil {
+		return err
+	}
+	if !pending.Pending {
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "The request is already closed", true)
+	}
+	if quorum := pending.Quorum; quorum == nil || !quorum.IsApprover(q.From.ID) {
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "You are not an approver of this request", true)
+	}
+	err = s.temporal.SignalWorkflow(ctx, callback.WorkflowID, "", workflows.ApprovalVoteSignal,
+		workflows.ApprovalVoteInput{
+			ActivityID: callback.ActivityID,
+			Vote: domain.ApprovalVote{
+				VoterID:   q.From.ID,
+				VoterName: q.From.UserName,
+				Status:    callback.Status,
+			},
+		})
+	if err != nil {
+		_ = s.telegram.AnswerCallbackQuery(ctx, q.ID, "Unable to record the vote, try again", true)
+		return err
+	}
+	return s.telegram.AnswerCallbackQuery(ctx, q.ID, fmt.Sprintf("Vote recorded: %s", callback.Status), false)
 }
 
 var sendCommandsOnce sync.Once
@@ -147,7 +394,23 @@ func (s *Service) handleStartCommand(ctx context.Context, u *tgrouter.Update) er
 			},
 			{
 				Command:     "/cancel",
-				Description: "Cancel the current ask request",
+				Description: "Cancel the current ask request or finish the conversation",
+			},
+			{
+				Command:     "/status",
+				Description: "Show where your request is",
+			},
+			{
+				Command:     "/usage",
+				Description: "Show your token usage and cost",
+			},
+			{
+				Command:     "/model",
+				Description: "Choose the model for the next requests",
+			},
+			{
+				Command:     "/persona",
+				Description: "Choose the persona for the next requests",
 			},
 		}
 		err = s.telegram.SetBotCommands(ctx, cmds)
@@ -155,6 +418,70 @@ func (s *Service) handleStartCommand(ctx context.Context, u *tgrouter.Update) er
 	return err
 }
 
+// handleStatusCommand shows the stage of the user's session, queried from the workflow.
+func (s *Service) handleStatusCommand(ctx context.Context, u *tgrouter.Update) error {
+	sm := s.getDialogSM(u.ChatID())
+	if sm == nil || sm.WorkflowID == "" {
+		return s.telegram.SendMessage(ctx, u.ChatID(), "You have no active request. Start a new one by /ask")
+	}
+	status, err := s.querySessionStatus(ctx, sm.WorkflowID, sm.WorkflowRunID)
+	if err != nil {
+		return err
+	}
+	if status.TurnWorkflowID != "" {
+		// The follow-up is handled by the ChatGPTTurn child workflow
+		turnStatus, err := s.querySessionStatus(ctx, status.TurnWorkflowID, "")
+		if err != nil {
+			return err
+		}
+		status.Stage = turnStatus.Stage
+		status.ApprovalStatus = turnStatus.ApprovalStatus
+		status.DecidedBy = turnStatus.DecidedBy
+		status.Usage.Add(turnStatus.Usage)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Stage: %s\nApproval: %s", status.Stage, status.ApprovalStatus)
+	if status.DecidedBy != "" {
+		fmt.Fprintf(&sb, " by @%s", status.DecidedBy)
+	}
+	fmt.Fprintf(&sb, "\nAnswered questions: %d\nHistory: %d messages\nUsage: %s",
+		status.Turns, status.HistoryLength, status.Usage)
+	return s.telegram.SendMessage(ctx, u.ChatID(), sb.String())
+}
+
+// handleUsageCommand shows the token usage of the user today, this month and in the current session.
+func (s *Service) handleUsageCommand(ctx context.Context, u *tgrouter.Update) error {
+	now := time.Now()
+	today, err := s.usage.Get(ctx, domain.UsageFilter{ChatID: u.ChatID(), From: domain.StartOfDay(now)})
+	if err != nil {
+		return err
+	}
+	month, err := s.usage.Get(ctx, domain.UsageFilter{ChatID: u.ChatID(), From: domain.StartOfMonth(now)})
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("Today: %s\nThis month: %s", today, month)
+	if sm := s.getDialogSM(u.ChatID()); sm != nil && sm.WorkflowID != "" {
+		session, err := s.usage.Get(ctx, domain.UsageFilter{ChatID: u.ChatID(), WorkflowIDPrefix: sm.WorkflowID})
+		if err != nil {
+			return err
+		}
+		text += fmt.Sprintf("\nCurrent conversation: %s", session)
+	}
+	return s.telegram.SendMessage(ctx, u.ChatID(), text)
+}
+
+func (s *Service) querySessionStatus(ctx context.Context, workflowID, runID string) (workflows.SessionStatus, error) {
+	var status workflows.SessionStatus
+	value, err := s.temporal.QueryWorkflow(ctx, workflowID, runID, workflows.SessionStatusQuery)
+	if err != nil {
+		return status, err
+	}
+	err = value.Get(&status)
+	return status, err
+}
+
 func (s *Service) handleStateMachineCreate(ctx context.Context, u *tgrouter.Update) error {
 	sm := s.getDialogSM(u.ChatID())
 	if sm == nil {
@@ -173,7 +500,7 @@ func (s *Service) handleStateMachine(ctx context.Context, u *tgrouter.Update) er
 	if sm == nil {
 		return fmt.Errorf("active state machine not found for chat %d", u.ChatID())
 	}
-	err := sm.Execute(ctx, &ChatMessage{Message: u.Message.Text, MessageID: u.Message.ID})
+	err := sm.Execute(ctx, newChatMessage(u.Message))
 	if err != nil {
 		return err
 	}
@@ -197,16 +524,106 @@ func (s *Service) getDialogSM(chatID int64) *PrivateChatStateMachine {
 	return s.dialogs[chatID]
 }
 
+// RestoreDialogs rebuilds state machines of the open sessions after the restart,
+// looking them up in Temporal by the session search attributes.
+// Sessions started by an older workflows.SessionVersion are closed instead.
+func (s *Service) RestoreDialogs(ctx context.Context) error {
+	return s.listRunningSessions(ctx, func(execution *workflowpb.WorkflowExecutionInfo) {
+		s.restoreDialog(ctx, execution)
+	})
+}
+
+// listRunningSessions calls fn for every running session from the latest one.
+func (s *Service) listRunningSessions(ctx context.Context, fn func(execution *workflowpb.WorkflowExecutionInfo)) error {
+	query := fmt.Sprintf("WorkflowType = '%s' AND ExecutionStatus = 'Running'", sessionWorkflowType)
+	var nextPageToken []byte
+	for {
+		resp, err := s.temporal.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Namespace:     client.DefaultNamespace,
+			Query:         query,
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			return err
+		}
+		for _, execution := range resp.GetExecutions() {
+			fn(execution)
+		}
+		nextPageToken = resp.GetNextPageToken()
+		if len(nextPageToken) == 0 {
+			return nil
+		}
+	}
+}
+
+// sessionWorkflowType is the registered name of workflows.ChatGTPSession.
+const sessionWorkflowType = "ChatGTPSession"
+
+func (s *Service) restoreDialog(ctx context.Context, execution *workflowpb.WorkflowExecutionInfo) {
+	fields := execution.GetSearchAttributes().GetIndexedFields()
+	var (
+		chatID         int64
+		userName       string
+		inConversation bool
+		version        int64
+	)
+	dc := converter.GetDefaultDataConverter()
+	err := dc.FromPayload(fields[workflows.ChatIDSearchAttribute.GetName()], &chatID)
+	if err == nil {
+		err = dc.FromPayload(fields[workflows.ChatUserNameSearchAttribute.GetName()], &userName)
+	}
+	if err == nil {
+		err = dc.FromPayload(fields[workflows.InConversationSearchAttribute.GetName()], &inConversation)
+	}
+	if err == nil {
+		// Sessions started before the versioning have no version
+		err = dc.FromPayload(fields[workflows.SessionVersionSearchAttribute.GetName()], &version)
+	}
+	if err == nil && version < workflows.SessionVersion {
+		s.closeOutdatedSession(ctx, execution, chatID)
+		return
+	}
+	if err != nil || chatID == 0 {
+		s.logger.WarnContext(ctx, "unable to restore session without search attributes",
+			slog.String("workflow_id", execution.GetExecution().GetWorkflowId()), slog.Any("error", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dialogs[chatID]; ok {
+		// Executions are listed from the latest one
+		return
+	}
+	sm := s.NewPrivateChatStateMachine(chatID, userName)
+	sm.WorkflowID = execution.GetExecution().GetWorkflowId()
+	sm.WorkflowRunID = execution.GetExecution().GetRunId()
+	sm.InConversation = inConversation
+	if inConversation {
+		sm.Set(sm.StateContinueConversation)
+	} else {
+		sm.Set(sm.StateWaitForApprove)
+	}
+	s.dialogs[chatID] = sm
+	s.logger.InfoContext(ctx, "session restored", slog.Int64("chat_id", chatID),
+		slog.String("workflow_id", sm.WorkflowID), slog.Bool("in_conversation", inConversation))
+}
+
 func (s *Service) handleForwarderGroupMessage(ctx context.Context, u *tgrouter.Update) error {
 	chatID := getUserFromMessageEntities(u.Update.Message)
+	if chatID == -1 {
+		// Not a request post (e.g. approval reminder)
+		return nil
+	}
 	sm := s.getDialogSM(chatID)
 	if sm == nil {
 		return fmt.Errorf("active state machine not found for chat %d", chatID)
 	}
 	err := s.temporal.SignalWorkflow(ctx, sm.WorkflowID, sm.WorkflowRunID, workflows.GetGroupMessageSignal,
 		workflows.GetGroupMessageInput{
-			MessageID: u.Message.ID,
-			GroupID:   u.ChatID(),
+			MessageID:        u.Message.ID,
+			GroupID:          u.ChatID(),
+			ChannelMessageID: u.Message.ForwardOrigin.MessageID,
 		})
 	if err != nil {
 		return err
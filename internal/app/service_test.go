@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	commonpb "go.temporal.io/api/common/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/converter"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/workflows"
+)
+
+func TestRestoreDialog(t *testing.T) {
+	execution := func(workflowID string, fields map[string]any) *workflowpb.WorkflowExecutionInfo {
+		indexed := make(map[string]*commonpb.Payload, len(fields))
+		for name, value := range fields {
+			payload, err := converter.GetDefaultDataConverter().ToPayload(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			indexed[name] = payload
+		}
+		return &workflowpb.WorkflowExecutionInfo{
+			Execution:        &commonpb.WorkflowExecution{WorkflowId: workflowID, RunId: workflowID + "-run"},
+			SearchAttributes: &commonpb.SearchAttributes{IndexedFields: indexed},
+		}
+	}
+	session := func(workflowID string, chatID int64, inConversation bool) *workflowpb.WorkflowExecutionInfo {
+		return execution(workflowID, map[string]any{
+			workflows.ChatIDSearchAttribute.GetName():         chatID,
+			workflows.ChatUserNameSearchAttribute.GetName():   "user",
+			workflows.InConversationSearchAttribute.GetName(): inConversation,
+			workflows.SessionVersionSearchAttribute.GetName(): int64(workflows.SessionVersion),
+		})
+	}
+
+	s := &Service{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		dialogs: make(map[int64]*PrivateChatStateMachine),
+	}
+	// Executions are listed from the latest one
+	for _, e := range []*workflowpb.WorkflowExecutionInfo{
+		session("waiting", 1, false),
+		session("conversation", 2, true),
+		session("older", 2, false),
+		execution("no-chat", map[string]any{
+			workflows.SessionVersionSearchAttribute.GetName(): int64(workflows.SessionVersion),
+		}),
+	} {
+		s.restoreDialog(context.Background(), e)
+	}
+
+	tests := []struct {
+		chatID         int64
+		workflowID     string
+		inConversation bool
+	}{
+		{1, "waiting", false},
+		{2, "conversation", true},
+	}
+	if len(s.dialogs) != len(tests) {
+		t.Errorf("restored %d dialogs, expected %d", len(s.dialogs), len(tests))
+	}
+	for _, tt := range tests {
+		sm := s.dialogs[tt.chatID]
+		if sm == nil {
+			t.Errorf("dialog of chat %d is not restored", tt.chatID)
+			continue
+		}
+		if sm.WorkflowID != tt.workflowID || sm.WorkflowRunID != tt.workflowID+"-run" {
+			t.Errorf("dialog of chat %d restored with workflow %s/%s, expected %s",
+				tt.chatID, sm.WorkflowID, sm.WorkflowRunID, tt.workflowID)
+		}
+		if sm.InConversation != tt.inConversation || sm.AcceptsRequest() != tt.inConversation {
+			t.Errorf("dialog of chat %d restored in conversation %t, accepts requests %t, expected %t",
+				tt.chatID, sm.InConversation, sm.AcceptsRequest(), tt.inConversation)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/converter"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/workflows"
+)
+
+// closeOutdatedSession terminates the session started by an older workflows.SessionVersion,
+// the current code can't replay its history. The user is asked to send the request again.
+// Sessions started before the versioning have no search attributes, the chat is read from the input.
+func (s *Service) closeOutdatedSession(ctx context.Context, execution *workflowpb.WorkflowExecutionInfo, chatID int64) {
+	workflowID, runID := execution.GetExecution().GetWorkflowId(), execution.GetExecution().GetRunId()
+	if chatID == 0 {
+		chatID = s.sessionChatID(ctx, workflowID, runID)
+	}
+	err := s.temporal.TerminateWorkflow(ctx, workflowID, runID, "session of the outdated version")
+	if err != nil {
+		s.logger.WarnContext(ctx, "unable to close outdated session",
+			slog.String("workflow_id", workflowID), slog.Any("error", err))
+		return
+	}
+	s.logger.InfoContext(ctx, "outdated session closed", slog.Int64("chat_id", chatID),
+		slog.String("workflow_id", workflowID))
+	if chatID == 0 {
+		return
+	}
+	err = s.telegram.SendMessage(ctx, chatID, "The bot was upgraded and your request was closed. Please send it again by /ask")
+	if err != nil {
+		s.logger.WarnContext(ctx, "unable to notify about closed session",
+			slog.Int64("chat_id", chatID), slog.Any("error", err))
+	}
+}
+
+// sessionChatID reads the chat from the input of the session, zero if it can't be read.
+func (s *Service) sessionChatID(ctx context.Context, workflowID, runID string) int64 {
+	events := s.temporal.GetWorkflowHistory(ctx, workflowID, runID, false, enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	if !events.HasNext() {
+		return 0
+	}
+	event, err := events.Next()
+	if err != nil {
+		return 0
+	}
+	var input workflows.ChatGPTSessionInput
+	err = converter.GetDefaultDataConverter().FromPayloads(
+		event.GetWorkflowExecutionStartedEventAttributes().GetInput(), &input)
+	if err != nil {
+		return 0
+	}
+	return input.ChatID
+}
+
+// DrainSessions refuses new requests and follow-ups, closes the conversations awaiting follow-ups
+// and returns once no session is running, so that a new workflows.SessionVersion can be deployed.
+// Sessions pending approval are left to finish by the approval deadline.
+func (s *Service) DrainSessions(ctx context.Context, interval time.Duration) error {
+	s.draining.Store(true)
+	for {
+		running := 0
+		err := s.listRunningSessions(ctx, func(execution *workflowpb.WorkflowExecutionInfo) {
+			running++
+			s.closeIdleSession(ctx, execution)
+		})
+		if err != nil {
+			return err
+		}
+		if running == 0 {
+			return nil
+		}
+		s.logger.InfoContext(ctx, "draining sessions", slog.Int("running", running))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// closeIdleSession ends the conversation of the session awaiting a follow-up.
+func (s *Service) closeIdleSession(ctx context.Context, execution *workflowpb.WorkflowExecutionInfo) {
+	workflowID, runID := execution.GetExecution().GetWorkflowId(), execution.GetExecution().GetRunId()
+	status, err := s.querySessionStatus(ctx, workflowID, runID)
+	if err != nil || status.Stage != workflows.SessionStageAwaitingFollowUp {
+		return
+	}
+	var chatID int64
+	fields := execution.GetSearchAttributes().GetIndexedFields()
+	_ = converter.GetDefaultDataConverter().FromPayload(fields[workflows.ChatIDSearchAttribute.GetName()], &chatID)
+	if sm := s.getDialogSM(chatID); sm != nil {
+		sm.Reset()
+		sm.Set(sm.StateNoop)
+	}
+	err = s.temporal.SignalWorkflow(ctx, workflowID, runID, workflows.EndConversationSignal, nil)
+	if err != nil {
+		s.logger.WarnContext(ctx, "unable to end conversation",
+			slog.String("workflow_id", workflowID), slog.Any("error", err))
+		return
+	}
+	if chatID != 0 {
+		_ = s.telegram.SendMessage(ctx, chatID, upgradeNotice)
+	}
+}
+
+const upgradeNotice = "The bot is being upgraded, the conversation is finished. Start a new one by /ask in a few minutes"
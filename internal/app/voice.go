@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+	"github.com/xenking/managed-tg-gpt-chat/pkg/tgrouter"
+)
+
+const (
+	voiceSendCallbackData    = "voice:send"
+	voiceDiscardCallbackData = "voice:discard"
+)
+
+// Limits of the voice messages, they bound the transcription cost of a single message.
+const (
+	maxVoiceDuration = 5 * time.Minute
+	maxVoiceFileSize = 10 << 20
+)
+
+// handleVoiceMessage transcribes the voice message and asks the user to confirm the transcript.
+// The transcript is the text of the confirmation message, so it needs no state until confirmed.
+// Voice messages are transcribed only when the state machine waits for the request or the follow-up.
+func (s *Service) handleVoiceMessage(ctx context.Context, u *tgrouter.Update) error {
+	sm := s.getDialogSM(u.ChatID())
+	switch {
+	case sm != nil && sm.AcceptsRequest():
+	case sm != nil && sm.WorkflowID != "":
+		return s.telegram.SendMessage(ctx, u.ChatID(), "Waiting for approval...\nYou can cancel the request by /cancel")
+	default:
+		return s.telegram.SendMessage(ctx, u.ChatID(), "Start a new request by /ask")
+	}
+	voice := u.Message.Voice
+	if time.Duration(voice.Duration)*time.Second > maxVoiceDuration || voice.FileSize > maxVoiceFileSize {
+		return s.telegram.SendMessage(ctx, u.ChatID(),
+			fmt.Sprintf("Voice messages up to %s are supported, please split your question", maxVoiceDuration))
+	}
+	audio, err := s.telegram.DownloadFile(ctx, voice.FileID)
+	if err != nil {
+		return err
+	}
+	transcript, err := s.speech.Transcribe(ctx, audio, "voice.ogg")
+	if err != nil {
+		return err
+	}
+	if transcript == "" {
+		return s.telegram.SendMessage(ctx, u.ChatID(), "Unable to recognize the voice message, please try again")
+	}
+
+	err = s.telegram.SendMessage(ctx, u.ChatID(),
+		"Transcript of your voice message. Send it as the request or type the corrected text:")
+	if err != nil {
+		return err
+	}
+	_, err = s.telegram.SendMessageHTMLWithInlineKeyboard(ctx, u.ChatID(), html.EscapeString(transcript),
+		[]domain.KeyboardButton{
+			{Text: "Send", CallbackData: voiceSendCallbackData},
+			{Text: "Discard", CallbackData: voiceDiscardCallbackData},
+		})
+	return err
+}
+
+// handleVoiceCallback sends the confirmed transcript to the state machine as if the user typed it.
+func (s *Service) handleVoiceCallback(ctx context.Context, u *tgrouter.Update) error {
+	q := u.CallbackQuery
+	transcript := q.Message.Text
+	if q.Data == voiceDiscardCallbackData {
+		err := s.telegram.AnswerCallbackQuery(ctx, q.ID, "Discarded", false)
+		if err != nil {
+			return err
+		}
+		return s.telegram.EditMessageHTML(ctx, q.Message.Chat.ID, domain.TelegramMessage{
+			ID:   q.Message.ID,
+			Text: fmt.Sprintf("<s>%s</s>", html.EscapeString(transcript)),
+		})
+	}
+
+	sm := s.getDialogSM(q.Message.Chat.ID)
+	if sm == nil {
+		return s.telegram.AnswerCallbackQuery(ctx, q.ID, "Start a new request by /ask", true)
+	}
+	err := s.telegram.AnswerCallbackQuery(ctx, q.ID, "Sent", false)
+	if err != nil {
+		return err
+	}
+	err = s.telegram.EditMessageHTML(ctx, q.Message.Chat.ID, domain.TelegramMessage{
+		ID:   q.Message.ID,
+		Text: fmt.Sprintf("<blockquote>%s</blockquote>", html.EscapeString(transcript)),
+	})
+	if err != nil {
+		return err
+	}
+	return sm.Execute(ctx, &ChatMessage{Message: transcript, MessageID: q.Message.ID})
+}
+
+// hasCallbackPrefix filters callback queries by the prefix of the data.
+func hasCallbackPrefix(prefix string) tgrouter.FilterMatcher {
+	return tgrouter.FilterFunc(func(u *tgrouter.Update) bool {
+		return u.CallbackQuery != nil && strings.HasPrefix(u.CallbackQuery.Data, prefix)
+	})
+}
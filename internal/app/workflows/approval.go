@@ -0,0 +1,332 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// ApprovalVoteSignal delivers a vote of a named approver for requests with domain.QuorumPolicy.
+const ApprovalVoteSignal = "approval-vote-signal"
+
+type ApprovalVoteInput struct {
+	ActivityID string
+	Vote       domain.ApprovalVote
+}
+
+// PendingApprovalQuery returns the PendingApproval of the workflow.
+// It fails until the request is sent to approvers, e.g. if the request was resolved automatically.
+const PendingApprovalQuery = "pending-approval-query"
+
+// PendingApproval lets the service check the decision before signalling it, so that
+// decisions the workflow would ignore are answered to the approver instead.
+type PendingApproval struct {
+	// Pending is false once the request is resolved.
+	Pending bool
+	// Quorum is set if the request is decided by the votes of named approvers.
+	Quorum *domain.QuorumPolicy
+	// MessageIDs are the approval posts, replies to them decide the request.
+	MessageIDs []int
+	// Redacted is set if approvers see the redacted request, it can't be edited then
+	// as the edit would replace the original text.
+	Redacted bool
+}
+
+// CancelRequestSignal cancels the request waiting for approvers by the user,
+// the workflow tells the user and closes the approval posts.
+const CancelRequestSignal = "cancel-request-signal"
+
+// ApprovalDecisionSignal delivers an approver decision made by a reply to the request,
+// carrying the reject reason or the edited request. It is sent to the workflow of the PendingApproval:
+// the session or the ChatGPTTurn of the follow-up.
+const ApprovalDecisionSignal = "approval-decision-signal"
+
+type ApprovalDecisionInput struct {
+	ChannelMessageID int
+	Status           domain.RequestStatus
+	Reason           string
+	EditedRequest    string
+	DecidedBy        int64
+	DecidedByName    string
+}
+
+// DefaultApprovalDeadline is used when domain.ApprovalPolicy.Deadline is not set.
+const DefaultApprovalDeadline = 10 * time.Minute
+
+// requestApproval runs activities.GetRequestApproval under the approval policy:
+// reminds approvers every ReminderInterval, escalates the request after EscalateAfter
+// and resolves it with DeadlineStatus once the Deadline is reached.
+// With the Quorum it records votes and completes the approval once the quorum is decided.
+func requestApproval(ctx workflow.Context, req activities.GetRequestApprovalRequest, policy domain.ApprovalPolicy) (activities.GetRequestApprovalResponse, error) {
+	req.Quorum = policy.Quorum
+	pending := PendingApproval{Pending: true, Quorum: policy.Quorum, Redacted: req.Redacted}
+	err := workflow.SetQueryHandler(ctx, PendingApprovalQuery, func() (PendingApproval, error) {
+		return pending, nil
+	})
+	if err != nil {
+		return activities.GetRequestApprovalResponse{}, err
+	}
+	deadline := policy.Deadline
+	if deadline <= 0 {
+		deadline = DefaultApprovalDeadline
+	}
+	deadlineStatus := policy.DeadlineStatus
+	if deadlineStatus == "" {
+		deadlineStatus = domain.RequestStatusRejected
+	}
+
+	// Cancels pending approvals and timers once the request is resolved
+	approvalCtx, cancelApproval := workflow.WithCancel(ctx)
+	defer cancelApproval()
+
+	opts := workflow.GetActivityOptions(approvalCtx)
+	opts.StartToCloseTimeout = deadline + time.Minute
+	opts.ScheduleToCloseTimeout = 0
+	opts.ActivityID = domain.ApprovalActivityID
+	primaryCtx := workflow.WithActivityOptions(approvalCtx, opts)
+	opts.ActivityID = domain.EscalationApprovalActivityID
+	escalationCtx := workflow.WithActivityOptions(approvalCtx, opts)
+
+	var (
+		resp     activities.GetRequestApprovalResponse
+		resolved bool
+		posted   []activities.ApprovalRequestedSignalInput
+	)
+	onDecision := func(f workflow.Future) {
+		err = f.Get(ctx, &resp)
+		resolved = true
+	}
+	remind := func(workflow.Future) {}
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(workflow.ExecuteActivity(primaryCtx, a.GetRequestApproval, req), onDecision)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, activities.ApprovalRequestedSignal), func(c workflow.ReceiveChannel, _ bool) {
+		var signal activities.ApprovalRequestedSignalInput
+		c.Receive(ctx, &signal)
+		posted = append(posted, signal)
+		pending.MessageIDs = append(pending.MessageIDs, signal.Message.ID)
+	})
+	selector.AddFuture(workflow.NewTimer(approvalCtx, deadline), func(workflow.Future) {
+		resp = activities.GetRequestApprovalResponse{
+			Message: fmt.Sprintf("Request %s: no decision within %s", deadlineStatus, deadline),
+			Status:  deadlineStatus,
+		}
+		resolved = true
+	})
+	if policy.EscalateAfter > 0 && policy.EscalateAfter < deadline && policy.EscalationChatID != 0 {
+		selector.AddFuture(workflow.NewTimer(approvalCtx, policy.EscalateAfter), func(workflow.Future) {
+			escalation := req
+			escalation.ChannelID = policy.EscalationChatID
+			escalation.EscalatedAfter = policy.EscalateAfter
+			selector.AddFuture(workflow.ExecuteActivity(escalationCtx, a.GetRequestApproval, escalation), onDecision)
+		})
+	}
+	selector.AddReceive(workflow.GetSignalChannel(ctx, CancelRequestSignal), func(c workflow.ReceiveChannel, _ bool) {
+		c.Receive(ctx, nil)
+		resp = activities.GetRequestApprovalResponse{
+			Message: "Request canceled",
+			Status:  domain.RequestStatusCanceled,
+		}
+		resolved = true
+	})
+	if policy.Quorum == nil {
+		selector.AddReceive(workflow.GetSignalChannel(ctx, ApprovalDecisionSignal), func(c workflow.ReceiveChannel, _ bool) {
+			var input ApprovalDecisionInput
+			c.Receive(ctx, &input)
+			if err = recordDecision(ctx, posted, input); err != nil {
+				resolved = true
+			}
+		})
+	}
+	var votes []domain.ApprovalVote
+	if policy.Quorum != nil {
+		selector.AddReceive(workflow.GetSignalChannel(ctx, ApprovalVoteSignal), func(c workflow.ReceiveChannel, _ bool) {
+			var input ApprovalVoteInput
+			c.Receive(ctx, &input)
+			if err = recordVote(ctx, *policy.Quorum, posted, &votes, input); err != nil {
+				resolved = true
+			}
+		})
+	}
+	if policy.ReminderInterval > 0 {
+		started := workflow.Now(ctx)
+		remind = func(workflow.Future) {
+			if len(posted) > 0 {
+				reminder := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.RemindApprovers, activities.RemindApproversRequest{
+					ChannelID:    posted[0].ChannelID,
+					MessageID:    posted[0].Message.ID,
+					ChatUserName: req.ChatUserName,
+					WaitingFor:   workflow.Now(ctx).Sub(started).Round(time.Minute).String(),
+				})
+				selector.AddFuture(reminder, func(f workflow.Future) {
+					// A missed reminder doesn't hold up the approval
+					if err := f.Get(ctx, nil); err != nil {
+						workflow.GetLogger(ctx).Warn("Unable to remind approvers", "error", err)
+					}
+				})
+			}
+			selector.AddFuture(workflow.NewTimer(approvalCtx, policy.ReminderInterval), remind)
+		}
+		selector.AddFuture(workflow.NewTimer(approvalCtx, policy.ReminderInterval), remind)
+	}
+
+	for !resolved {
+		selector.Select(ctx)
+	}
+	pending = PendingApproval{}
+	cancelApproval()
+	if err != nil {
+		return resp, err
+	}
+
+	// Approval messages which didn't take the decision are left with buttons, close them.
+	// Signals of messages posted right before the decision may still be buffered.
+	approvalSignals := workflow.GetSignalChannel(ctx, activities.ApprovalRequestedSignal)
+	for {
+		var signal activities.ApprovalRequestedSignalInput
+		if !approvalSignals.ReceiveAsync(&signal) {
+			break
+		}
+		posted = append(posted, signal)
+	}
+	autoResolved := resp.MessageID == 0
+	decidedMessageID := resp.MessageID
+	reason := ""
+	if autoResolved {
+		reason = resp.Message
+	}
+	for _, p := range posted {
+		if p.ChannelID == req.ChannelID {
+			// The audit channel post identifies the request further on
+			resp.MessageID = p.Message.ID
+		}
+		if p.Message.ID == decidedMessageID || (policy.Quorum != nil && !autoResolved) {
+			// Quorum votes already show the decision in every message
+			continue
+		}
+		err = workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.CloseApprovalRequest, activities.CloseApprovalRequestRequest{
+			ChannelID: p.ChannelID,
+			Message:   p.Message,
+			Status:    resp.Status,
+			Reason:    reason,
+		}).Get(ctx, nil)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	if autoResolved && resp.Status == domain.RequestStatusApproved {
+		err = workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.NotifyUser, activities.NotifyUserRequest{
+			ChatID:  req.ChatID,
+			Message: resp.Message,
+		}).Get(ctx, nil)
+	}
+	return resp, err
+}
+
+// recordDecision closes the approval message the decision replied to and completes the approval.
+func recordDecision(ctx workflow.Context, posted []activities.ApprovalRequestedSignalInput, input ApprovalDecisionInput) error {
+	for _, p := range posted {
+		if p.Message.ID != input.ChannelMessageID {
+			continue
+		}
+		resp := activities.GetRequestApprovalResponse{
+			MessageID:     p.Message.ID,
+			Message:       fmt.Sprintf("Request %s", input.Status),
+			Status:        input.Status,
+			EditedRequest: input.EditedRequest,
+			DecidedBy:     input.DecidedBy,
+			DecidedByName: input.DecidedByName,
+		}
+		reason := "by @" + input.DecidedByName
+		switch {
+		case input.Reason != "":
+			resp.Message += ": " + input.Reason
+			reason += ": " + input.Reason
+		case input.EditedRequest != "":
+			reason += " with edited request"
+		}
+		err := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.CloseApprovalRequest, activities.CloseApprovalRequestRequest{
+			ChannelID: p.ChannelID,
+			Message:   p.Message,
+			Status:    input.Status,
+			Reason:    reason,
+		}).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		return workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.CompleteApproval, activities.CompleteApprovalRequest{
+			WorkflowID: p.Callback.WorkflowID,
+			ActivityID: p.Callback.ActivityID,
+			Response:   resp,
+		}).Get(ctx, nil)
+	}
+	return nil
+}
+
+// recordVote replaces the previous vote of the approver, shows the tally in the approval messages
+// and completes the approval once the quorum is reached or can no longer be reached.
+func recordVote(ctx workflow.Context, quorum domain.QuorumPolicy, posted []activities.ApprovalRequestedSignalInput,
+	votes *[]domain.ApprovalVote, input ApprovalVoteInput,
+) error {
+	if !quorum.IsApprover(input.Vote.VoterID) {
+		return nil
+	}
+	recorded := false
+	for i, vote := range *votes {
+		if vote.VoterID == input.Vote.VoterID {
+			(*votes)[i] = input.Vote
+			recorded = true
+		}
+	}
+	if !recorded {
+		*votes = append(*votes, input.Vote)
+	}
+
+	for _, p := range posted {
+		err := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.UpdateApprovalVotes, activities.UpdateApprovalVotesRequest{
+			ChannelID: p.ChannelID,
+			Callback:  p.Callback,
+			Message:   p.Message,
+			Quorum:    quorum,
+			Votes:     *votes,
+		}).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	approved, rejected, status := quorum.TallyVotes(*votes)
+	if status == domain.RequestStatusPending {
+		return nil
+	}
+	var messageID int
+	for _, p := range posted {
+		if p.Callback.ActivityID == input.ActivityID {
+			messageID = p.Message.ID
+		}
+	}
+	return workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.CompleteApproval, activities.CompleteApprovalRequest{
+		WorkflowID: workflow.GetInfo(ctx).WorkflowExecution.ID,
+		ActivityID: input.ActivityID,
+		Response: activities.GetRequestApprovalResponse{
+			MessageID:     messageID,
+			Message:       fmt.Sprintf("Request %s by quorum: %d approved, %d rejected", status, approved, rejected),
+			Status:        status,
+			DecidedBy:     input.Vote.VoterID,
+			DecidedByName: input.Vote.VoterName,
+		},
+	}).Get(ctx, nil)
+}
+
+// auxiliaryActivityCtx drops the fixed activity ID so that activities can run
+// next to the pending approval.
+func auxiliaryActivityCtx(ctx workflow.Context) workflow.Context {
+	opts := workflow.GetActivityOptions(ctx)
+	opts.ActivityID = ""
+	return workflow.WithActivityOptions(ctx, opts)
+}
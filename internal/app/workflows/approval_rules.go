@@ -0,0 +1,100 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// approveRequest resolves the request before it is sent to Chat GPT.
+// The request is redacted for the audit channel and rejected if the moderation policy blocks it.
+// Requests over the quota are rejected or always need the approval, depending on the quota policy.
+// Otherwise the first matching approval rule approves, rejects or sends the request to approvers,
+// and requests well under the budget skip the approval if no rule matched.
+// Requests which need the quorum or are flagged by the moderation are never approved automatically.
+func approveRequest(ctx workflow.Context, req activities.GetRequestApprovalRequest,
+	policy domain.ApprovalPolicy, quotaPolicy domain.QuotaPolicy, rules []domain.ApprovalRule,
+	moderationPolicy domain.ModerationPolicy,
+) (activities.GetRequestApprovalResponse, error) {
+	var moderation activities.ModerateContentResponse
+	err := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.ModerateContent, activities.ModerateContentRequest{
+		Request:     req.Request,
+		Attachments: req.Attachments,
+		Policy:      moderationPolicy,
+	}).Get(ctx, &moderation)
+	if err != nil {
+		return activities.GetRequestApprovalResponse{}, err
+	}
+	request := req.Request
+	req.Request = moderation.Redacted
+	req.Redacted = moderation.Redacted != request
+	req.Flags = moderation.Flags
+	if moderation.Blocked != "" {
+		return resolveRequest(ctx, req, domain.RequestStatusRejected, moderation.Blocked)
+	}
+	flagged := moderation.Flags != ""
+
+	var quota activities.CheckQuotaResponse
+	err = workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.CheckQuota, activities.CheckQuotaRequest{
+		ChatID: req.ChatID,
+		Policy: quotaPolicy,
+	}).Get(ctx, &quota)
+	if err != nil {
+		return activities.GetRequestApprovalResponse{}, err
+	}
+	overQuota := quota.Status == domain.QuotaStatusOver
+	if overQuota && quotaPolicy.OverQuotaStatus == domain.RequestStatusRejected {
+		return resolveRequest(ctx, req, domain.RequestStatusRejected, "quota exceeded, "+quota.Message)
+	}
+
+	rule, matched := domain.MatchApprovalRule(rules, domain.RuleRequest{
+		UserID:     req.ChatID,
+		Request:    request,
+		Time:       workflow.Now(ctx),
+		BudgetUsed: quota.Used,
+	})
+	switch {
+	case matched && rule.Action == domain.RuleActionReject:
+		return resolveRequest(ctx, req, domain.RequestStatusRejected, "rule "+rule.Name)
+	case matched && rule.Action == domain.RuleActionApprove && !overQuota && !flagged && policy.Quorum == nil:
+		return resolveRequest(ctx, req, domain.RequestStatusApproved, "rule "+rule.Name)
+	case !matched && quota.Status == domain.QuotaStatusUnder && !flagged && policy.Quorum == nil:
+		return resolveRequest(ctx, req, domain.RequestStatusApproved, "under budget")
+	}
+
+	if matched {
+		req.Rule = rule.Name
+	}
+	req.Quota = quota.Message
+	if overQuota {
+		req.Quota = "over budget, " + quota.Message
+	}
+	// Requests resolved automatically get the answer or the reject reason instead
+	notice := "Waiting for approval...\nYou can cancel the request by /cancel"
+	if len(req.History) > 0 {
+		// Only follow-ups carry the conversation
+		notice = "Waiting for approval of the follow-up..."
+	}
+	err = workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.NotifyUser, activities.NotifyUserRequest{
+		ChatID:  req.ChatID,
+		Message: notice,
+	}).Get(ctx, nil)
+	if err != nil {
+		return activities.GetRequestApprovalResponse{}, err
+	}
+	return requestApproval(ctx, req, policy)
+}
+
+// resolveRequest logs the request resolved without approvers in the audit channel.
+func resolveRequest(ctx workflow.Context, req activities.GetRequestApprovalRequest,
+	status domain.RequestStatus, reason string,
+) (activities.GetRequestApprovalResponse, error) {
+	var resp activities.GetRequestApprovalResponse
+	err := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.PostResolvedRequest, activities.PostResolvedRequestRequest{
+		Request: req,
+		Status:  status,
+		Reason:  reason,
+	}).Get(ctx, &resp)
+	return resp, err
+}
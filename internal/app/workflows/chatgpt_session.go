@@ -1,7 +1,10 @@
 package workflows
 
 import (
+	"errors"
 	"fmt"
+	"html"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,11 +15,33 @@ import (
 	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
 )
 
-const GetGroupMessageSignal = "group-message-id-signal"
+const (
+	GetGroupMessageSignal      = "group-message-id-signal"
+	ContinueConversationSignal = "continue-conversation-signal"
+	EndConversationSignal      = "end-conversation-signal"
+)
+
+// SessionVersion is the version of the ChatGTPSession and ChatGPTTurn command sequence.
+// Running sessions replay their history on the new code after a deploy, so a change of the sequence
+// must either be gated by workflow.GetVersion or bump the SessionVersion. Sessions of older versions
+// are terminated on startup, to let them finish run the previous release with DRAIN_SESSIONS
+// until it exits, then deploy the new one.
+const SessionVersion = 3
+
+// DefaultConversationIdleTimeout is used when ChatGPTSessionInput.IdleTimeout is not set.
+const DefaultConversationIdleTimeout = 30 * time.Minute
 
 type GetGroupMessageInput struct {
 	MessageID int
 	GroupID   int64
+	// ChannelMessageID is the ID of the audit channel post the group message was forwarded from.
+	ChannelMessageID int
+}
+
+type ContinueConversationInput struct {
+	Request     string
+	Images      []domain.ChatImage
+	Attachments []domain.Attachment
 }
 
 type ChatGPTSessionInput struct {
@@ -26,44 +51,96 @@ type ChatGPTSessionInput struct {
 	ChatID       int64
 	ChatUserName string
 	Request      string
+	Images       []domain.ChatImage
+	Attachments  []domain.Attachment
+	// ChatOptions are chosen by the user, e.g. the model.
+	ChatOptions domain.ChatOptions
+	// VisionModels accept images, the first one answers conversations with images
+	// if the chosen model is not among them.
+	VisionModels []string
+	// IdleTimeout closes the conversation if no follow-up arrives in time.
+	IdleTimeout    time.Duration
+	ApprovalPolicy domain.ApprovalPolicy
+	// QuorumPolicies select the quorum of each follow-up, the first request comes with the ApprovalPolicy.Quorum.
+	QuorumPolicies []domain.QuorumPolicy
+	QuotaPolicy    domain.QuotaPolicy
+	// ApprovalRules are kept in the input so that the workflow replays with the same rules.
+	ApprovalRules []domain.ApprovalRule
+	// ModerationPolicy screens the requests before they are posted to approvers.
+	ModerationPolicy domain.ModerationPolicy
 }
 
 type ChatGPTSessionOutput struct {
-	Status   domain.RequestStatus
-	Response string
+	Status    domain.RequestStatus
+	Response  string
+	Turns     int
+	DecidedBy string
+	Usage     domain.TokenUsage
 }
 
 // ChatGTPSession is a Temporal workflow
 // that orchestrates the approval of a chat GPT request
-// activities.GetRequestApproval
+// activities.ModerateContent redacts the request for the audit channel or blocks it
+// activities.CheckQuota and the approval rules, then activities.PostResolvedRequest if resolved automatically
+// or activities.GetRequestApproval under the approval policy (reminders, escalation, deadline)
 // switch based on response
-// activities.RequestToChatGPT or activities.RejectChatRequest
+// activities.StreamChatGPTResponse or activities.RejectChatRequest
 // activities.ConvertToHTML
 // activities.RespondToUser
 // wait for new message in group
 // activities.CommentRequestWithResponse
-// TODO: child workflow (with approval) for chat continuation
+// loop over follow-ups received by ContinueConversationSignal until
+// EndConversationSignal or idle timeout:
+// activities.SummarizeHistory of the oldest turns, if the history doesn't fit the context policy
+// ChatGPTTurn child workflow (with approval) with the whole history
+// activities.CommentRequestWithResponse
 func ChatGTPSession(ctx workflow.Context, input ChatGPTSessionInput) (ChatGPTSessionOutput, error) {
-	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
-		StartToCloseTimeout:    time.Hour,
-		ScheduleToCloseTimeout: 10 * time.Minute,
-		ActivityID:             input.WorkflowActivityID,
-		RetryPolicy: &temporal.RetryPolicy{
-			InitialInterval: 5 * time.Second,
-			MaximumAttempts: 10,
-		},
+	ctx = withActivityOptions(ctx, input.WorkflowActivityID)
+
+	status := SessionStatus{
+		Stage:          SessionStageAwaitingApproval,
+		ApprovalStatus: domain.RequestStatusPending,
+	}
+	err := setStatusQueryHandler(ctx, &status)
+	if err != nil {
+		return ChatGPTSessionOutput{}, err
+	}
+	defer func() { status.Stage = SessionStageClosed }()
+
+	// Audit channel posts are forwarded to the group one per approval request,
+	// collect them to comment each request in its own thread
+	groupMessages := make(map[int]GetGroupMessageInput)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		ch := workflow.GetSignalChannel(ctx, GetGroupMessageSignal)
+		for {
+			var groupMessageInput GetGroupMessageInput
+			ch.Receive(ctx, &groupMessageInput)
+			groupMessages[groupMessageInput.ChannelMessageID] = groupMessageInput
+		}
 	})
 
-	var approvalResp activities.GetRequestApprovalResponse
-	err := workflow.ExecuteActivity(ctx, a.GetRequestApproval, activities.GetRequestApprovalRequest{
+	history := []domain.ChatMessage{{
+		Role:        domain.ChatMessageRoleUser,
+		Content:     input.Request,
+		Images:      input.Images,
+		Attachments: input.Attachments,
+	}}
+	opts := visionOptions(input.ChatOptions, input.VisionModels, history...)
+	approvalResp, err := approveRequest(ctx, activities.GetRequestApprovalRequest{
 		ChannelID:    input.AuditLogChannelID,
 		ChatID:       input.ChatID,
 		ChatUserName: input.ChatUserName,
 		Request:      input.Request,
-	}).Get(ctx, &approvalResp)
+		Images:       input.Images,
+		Attachments:  input.Attachments,
+		Model:        opts.Model,
+		Persona:      opts.Persona,
+	}, input.ApprovalPolicy, input.QuotaPolicy, input.ApprovalRules,
+		input.ModerationPolicy)
 	if err != nil {
 		return ChatGPTSessionOutput{}, err
 	}
+	status.setApprovalStatus(approvalResp)
 
 	switch approvalResp.Status {
 	case domain.RequestStatusRejected, domain.RequestStatusCanceled:
@@ -72,57 +149,351 @@ func ChatGTPSession(ctx workflow.Context, input ChatGPTSessionInput) (ChatGPTSes
 			RejectMessage: approvalResp.Message,
 		}).Get(ctx, nil)
 		return ChatGPTSessionOutput{
-			Status:   approvalResp.Status,
-			Response: fmt.Sprintf("Request %s", approvalResp.Status),
+			Status:    approvalResp.Status,
+			Response:  fmt.Sprintf("Request %s", approvalResp.Status),
+			DecidedBy: approvalResp.DecidedByName,
 		}, err
 	}
 
-	var chatResp activities.SendChatGPTRequestResponse
-	err = workflow.ExecuteActivity(ctx, a.SendChatGPTRequest, activities.SendChatGPTRequestRequest{
-		Request: input.Request,
-	}).Get(ctx, &chatResp)
+	request, err := applyEditedRequest(ctx, input.ChatID, input.Request, approvalResp)
 	if err != nil {
 		return ChatGPTSessionOutput{}, err
 	}
 
-	var htmlResp activities.ConvertToHTMLResponse
-	err = workflow.ExecuteActivity(ctx, a.ConvertToHTML, activities.ConvertToHTMLRequest{
-		ChatResponse: chatResp.Responses,
-	}).Get(ctx, &htmlResp)
+	// Lets the restarted service restore the chat state machine in the conversation
+	err = workflow.UpsertTypedSearchAttributes(ctx, InConversationSearchAttribute.ValueSet(true))
 	if err != nil {
 		return ChatGPTSessionOutput{}, err
 	}
 
-	messages := splitMaxLimitMessages(htmlResp.HTMLContent)
-
-	err = workflow.ExecuteActivity(ctx, a.RespondToUser, activities.RespondToUserRequest{
-		ChatID:   input.ChatID,
-		Messages: messages,
-	}).Get(ctx, nil)
+	history[0].Content = request
+	responses, messages, err := answerConversation(ctx, &status, input.ChatID, opts, history)
 	if err != nil {
 		return ChatGPTSessionOutput{}, err
 	}
+	history = append(history, responses...)
+	status.Turns = 1
+	status.HistoryLength = len(history)
 
 	// Block until we receive a new message in group
-	var groupMessageInput GetGroupMessageInput
-	workflow.GetSignalChannel(ctx, GetGroupMessageSignal).Receive(ctx, &groupMessageInput)
+	status.Stage = SessionStageAwaitingGroupMessage
+	groupMessageInput, err := waitForGroupMessage(ctx, groupMessages, approvalResp.MessageID)
+	if err != nil {
+		return ChatGPTSessionOutput{}, err
+	}
 
 	err = workflow.ExecuteActivity(ctx, a.CommentRequestWithResponse, activities.CommentRequestWithResponse{
 		GroupID:   groupMessageInput.GroupID,
 		MessageID: groupMessageInput.MessageID,
-		Request:   input.Request,
+		Request:   request,
 		Responses: messages,
+		// Only the first answer is accounted so far
+		Usage: status.Usage,
 	}).Get(ctx, nil)
 	if err != nil {
 		return ChatGPTSessionOutput{}, err
 	}
 
+	idleTimeout := input.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultConversationIdleTimeout
+	}
+	for followUps := 1; ; followUps++ {
+		status.Stage = SessionStageAwaitingFollowUp
+		followUp, ok := waitForFollowUp(ctx, idleTimeout)
+		if !ok {
+			break
+		}
+
+		status.Stage = SessionStageFollowUp
+		// Images stay in the history, so the model is not switched back
+		opts = visionOptions(opts, input.VisionModels, domain.ChatMessage{Images: followUp.Images})
+		// The follow-up may need the quorum even if the first request didn't
+		approvalPolicy := input.ApprovalPolicy
+		approvalPolicy.Quorum = domain.MatchQuorumPolicy(input.QuorumPolicies, followUp.Request)
+		turnOutput, ok, err := executeTurn(ctx, &status, ChatGPTTurnInput{
+			WorkflowActivityID: input.WorkflowActivityID,
+			AuditLogChannelID:  input.AuditLogChannelID,
+			ChatID:             input.ChatID,
+			ChatUserName:       input.ChatUserName,
+			ChatOptions:        opts,
+			Request:            followUp.Request,
+			Images:             followUp.Images,
+			Attachments:        followUp.Attachments,
+			History:            history,
+			ApprovalPolicy:     approvalPolicy,
+			QuotaPolicy:        input.QuotaPolicy,
+			ApprovalRules:      input.ApprovalRules,
+			ModerationPolicy:   input.ModerationPolicy,
+		}, followUps)
+		if err != nil {
+			return ChatGPTSessionOutput{}, err
+		}
+		if !ok {
+			// The conversation was ended while the turn was in progress
+			break
+		}
+		status.Usage.Add(turnOutput.Usage)
+		status.ApprovalStatus = turnOutput.Status
+		status.DecidedBy = turnOutput.DecidedBy
+		if turnOutput.Status != domain.RequestStatusApproved {
+			continue
+		}
+		history = turnOutput.History
+		responses = turnOutput.Responses
+		status.Turns++
+		status.HistoryLength = len(history)
+
+		status.Stage = SessionStageAwaitingGroupMessage
+		groupMessageInput, err = waitForGroupMessage(ctx, groupMessages, turnOutput.ApprovalMessageID)
+		if err != nil {
+			return ChatGPTSessionOutput{}, err
+		}
+		err = workflow.ExecuteActivity(ctx, a.CommentRequestWithResponse, activities.CommentRequestWithResponse{
+			GroupID:   groupMessageInput.GroupID,
+			MessageID: groupMessageInput.MessageID,
+			Request:   turnOutput.Request,
+			Responses: turnOutput.Messages,
+			Usage:     turnOutput.Usage,
+		}).Get(ctx, nil)
+		if err != nil {
+			return ChatGPTSessionOutput{}, err
+		}
+	}
+
 	return ChatGPTSessionOutput{
-		Status:   domain.RequestStatusApproved,
-		Response: fmt.Sprintf("Responses: %s", chatResp.Responses),
+		Status:    domain.RequestStatusApproved,
+		Response:  fmt.Sprintf("Responses: %v", responses),
+		Turns:     status.Turns,
+		DecidedBy: approvalResp.DecidedByName,
+		Usage:     status.Usage,
 	}, nil
 }
 
+// executeTurn runs the ChatGPTTurn child workflow for the follow-up question.
+// Approver decisions are signalled to the child directly, see PendingApprovalQuery.
+// It returns false if the conversation was ended before the turn completed.
+func executeTurn(ctx workflow.Context, status *SessionStatus, input ChatGPTTurnInput, turn int) (ChatGPTTurnOutput, bool, error) {
+	childCtx, cancelChild := workflow.WithCancel(ctx)
+	defer cancelChild()
+	childID := fmt.Sprintf("%s-turn-%d", workflow.GetInfo(ctx).WorkflowExecution.ID, turn)
+	status.TurnWorkflowID = childID
+	defer func() { status.TurnWorkflowID = "" }()
+	childCtx = workflow.WithChildOptions(childCtx, workflow.ChildWorkflowOptions{
+		WorkflowID: childID,
+	})
+
+	var (
+		output ChatGPTTurnOutput
+		err    error
+		done   bool
+		ended  bool
+	)
+	selector := workflow.NewSelector(ctx).
+		AddFuture(workflow.ExecuteChildWorkflow(childCtx, ChatGPTTurn, input), func(f workflow.Future) {
+			err = f.Get(ctx, &output)
+			done = true
+		}).
+		AddReceive(workflow.GetSignalChannel(ctx, EndConversationSignal), func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, nil)
+			ended = true
+		})
+	for !done && !ended {
+		selector.Select(ctx)
+	}
+	if ended {
+		return ChatGPTTurnOutput{}, false, nil
+	}
+	return output, true, err
+}
+
+// applyEditedRequest returns the request approved for sending to Chat GPT
+// and lets the user know if the approver edited it.
+func applyEditedRequest(ctx workflow.Context, chatID int64, request string, approvalResp activities.GetRequestApprovalResponse) (string, error) {
+	if approvalResp.EditedRequest == "" || approvalResp.EditedRequest == request {
+		return request, nil
+	}
+	err := workflow.ExecuteActivity(ctx, a.NotifyUser, activities.NotifyUserRequest{
+		ChatID:  chatID,
+		Message: fmt.Sprintf("Your request was edited by the approver:\n%s", approvalResp.EditedRequest),
+	}).Get(ctx, nil)
+	return approvalResp.EditedRequest, err
+}
+
+func waitForGroupMessage(ctx workflow.Context, groupMessages map[int]GetGroupMessageInput, channelMessageID int) (GetGroupMessageInput, error) {
+	err := workflow.Await(ctx, func() bool {
+		_, ok := groupMessages[channelMessageID]
+		return ok
+	})
+	if err != nil {
+		return GetGroupMessageInput{}, err
+	}
+	groupMessageInput := groupMessages[channelMessageID]
+	delete(groupMessages, channelMessageID)
+	return groupMessageInput, nil
+}
+
+// knowledgeTopK is the number of the chunks of the internal docs added to the request.
+const knowledgeTopK = 3
+
+// maxToolRounds limits the tool calls of a single answer, then the model has to answer without tools.
+const maxToolRounds = 5
+
+// answerConversation adds the internal docs found by activities.RetrieveKnowledge to the request,
+// sends the whole history to Chat GPT, runs the tools it calls by activities.CallTool until the final answer
+// arrives, streams the answer to the user and replaces the streamed text with the converted HTML
+// listing the sources of the docs.
+// It returns the messages to be appended to the history, including the tool calls,
+// and the HTML parts sent to the user.
+func answerConversation(ctx workflow.Context, status *SessionStatus, chatID int64, opts domain.ChatOptions,
+	history []domain.ChatMessage,
+) ([]domain.ChatMessage, []string, error) {
+	var knowledge activities.RetrieveKnowledgeResponse
+	err := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.RetrieveKnowledge, activities.RetrieveKnowledgeRequest{
+		Query: history[len(history)-1].Content,
+		TopK:  knowledgeTopK,
+	}).Get(ctx, &knowledge)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The docs are sent with this answer only, so the history returned to the caller stays small
+	request := slices.Clone(history)
+	request[len(request)-1].Knowledge = knowledge.Chunks
+
+	var (
+		chatResp activities.StreamChatGPTResponseResponse
+		answer   []domain.ChatMessage
+	)
+	for round := 0; ; round++ {
+		status.Stage = SessionStageCallingGPT
+		opts.NoTools = round >= maxToolRounds
+		var resp activities.StreamChatGPTResponseResponse
+		err = workflow.ExecuteActivity(gptActivityCtx(ctx), a.StreamChatGPTResponse, activities.StreamChatGPTResponseRequest{
+			ChatID:    chatID,
+			Options:   opts,
+			Messages:  append(request[:len(request):len(request)], answer...),
+			MessageID: chatResp.MessageID,
+		}).Get(ctx, &resp)
+		if err != nil {
+			return nil, nil, notifyGPTFailure(ctx, chatID, err)
+		}
+		chatResp = resp
+		status.Usage.Add(chatResp.Usage)
+		answer = append(answer, chatResp.Responses...)
+
+		var calls []domain.ToolCall
+		for _, msg := range chatResp.Responses {
+			calls = append(calls, msg.ToolCalls...)
+		}
+		if len(calls) == 0 {
+			break
+		}
+		status.Stage = SessionStageCallingTools
+		for _, call := range calls {
+			var toolResp activities.CallToolResponse
+			err = workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.CallTool, activities.CallToolRequest{
+				Call: call,
+			}).Get(ctx, &toolResp)
+			if err != nil {
+				return nil, nil, err
+			}
+			answer = append(answer, toolResp.Message)
+		}
+	}
+
+	status.Stage = SessionStageResponding
+	var htmlResp activities.ConvertToHTMLResponse
+	err = workflow.ExecuteActivity(ctx, a.ConvertToHTML, activities.ConvertToHTMLRequest{
+		ChatResponse: chatResp.Responses,
+	}).Get(ctx, &htmlResp)
+	if err != nil {
+		return nil, nil, err
+	}
+	htmlResp.HTMLContent += formatKnowledgeSources(knowledge.Chunks)
+
+	messages := splitMaxLimitMessages(htmlResp.HTMLContent)
+
+	err = workflow.ExecuteActivity(ctx, a.RespondToUser, activities.RespondToUserRequest{
+		ChatID:        chatID,
+		Messages:      messages,
+		EditMessageID: chatResp.MessageID,
+	}).Get(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return answer, messages, nil
+}
+
+// notifyGPTFailure tells the user why the request failed once Chat GPT requests are no longer retried,
+// the error is returned as is.
+func notifyGPTFailure(ctx workflow.Context, chatID int64, err error) error {
+	if temporal.IsCanceledError(err) {
+		return err
+	}
+	kind := domain.GPTErrorUnavailable
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		kind = domain.GPTErrorKind(appErr.Type())
+	}
+	notifyErr := workflow.ExecuteActivity(auxiliaryActivityCtx(ctx), a.NotifyUser, activities.NotifyUserRequest{
+		ChatID:  chatID,
+		Message: kind.UserMessage(),
+	}).Get(ctx, nil)
+	if notifyErr != nil {
+		workflow.GetLogger(ctx).Warn("Unable to notify user about failed request", "error", notifyErr)
+	}
+	return err
+}
+
+// formatKnowledgeSources lists the files of the docs the answer is based on.
+func formatKnowledgeSources(chunks []domain.KnowledgeChunk) string {
+	var sources []string
+	for _, chunk := range chunks {
+		if !slices.Contains(sources, chunk.Source) {
+			sources = append(sources, chunk.Source)
+		}
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+	for i, source := range sources {
+		sources[i] = "<code>" + html.EscapeString(source) + "</code>"
+	}
+	return "\n\n<i>Sources:</i> " + strings.Join(sources, ", ")
+}
+
+// visionOptions switches to the vision model once the conversation has images,
+// the chosen model is kept if it accepts images.
+func visionOptions(opts domain.ChatOptions, visionModels []string, msgs ...domain.ChatMessage) domain.ChatOptions {
+	if len(visionModels) == 0 || !domain.HasImages(msgs...) || slices.Contains(visionModels, opts.Model) {
+		return opts
+	}
+	opts.Model = visionModels[0]
+	return opts
+}
+
+// waitForFollowUp blocks until the user sends a follow-up question.
+// It returns false if the conversation was ended by the user or the idle timeout expired.
+func waitForFollowUp(ctx workflow.Context, idleTimeout time.Duration) (ContinueConversationInput, bool) {
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	var followUp ContinueConversationInput
+	received := false
+	workflow.NewSelector(ctx).
+		AddReceive(workflow.GetSignalChannel(ctx, ContinueConversationSignal), func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, &followUp)
+			received = true
+		}).
+		AddReceive(workflow.GetSignalChannel(ctx, EndConversationSignal), func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, nil)
+		}).
+		AddFuture(workflow.NewTimer(timerCtx, idleTimeout), func(workflow.Future) {}).
+		Select(ctx)
+	return followUp, received
+}
+
 const MaxTelegramMessageSize = 4096 - 128
 
 func splitMaxLimitMessages(text string) []string {
@@ -0,0 +1,129 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+type ChatGPTTurnInput struct {
+	WorkflowActivityID string
+	AuditLogChannelID  int64
+
+	ChatID       int64
+	ChatUserName string
+	Request      string
+	Images       []domain.ChatImage
+	Attachments  []domain.Attachment
+	ChatOptions  domain.ChatOptions
+	// History is the conversation so far, owned by the parent session.
+	History        []domain.ChatMessage
+	ApprovalPolicy domain.ApprovalPolicy
+	QuotaPolicy    domain.QuotaPolicy
+	// ApprovalRules are kept in the input so that the workflow replays with the same rules.
+	ApprovalRules []domain.ApprovalRule
+	// ModerationPolicy screens the requests before they are posted to approvers.
+	ModerationPolicy domain.ModerationPolicy
+}
+
+type ChatGPTTurnOutput struct {
+	Status            domain.RequestStatus
+	ApprovalMessageID int
+	// Request is the follow-up sent to Chat GPT, possibly edited by the approver.
+	Request   string
+	Responses []domain.ChatMessage
+	// History is the conversation after the turn, fitted to the context policy of the options.
+	History   []domain.ChatMessage
+	Messages  []string
+	DecidedBy string
+	Usage     domain.TokenUsage
+}
+
+// ChatGPTTurn is a Temporal child workflow of ChatGTPSession
+// that handles a single follow-up question of the conversation
+// activities.ModerateContent redacts the request for the audit channel or blocks it
+// activities.CheckQuota and the approval rules, then activities.GetRequestApproval with the thread context under the approval policy
+// switch based on response
+// activities.SummarizeHistory if the approved request doesn't fit the context,
+// activities.StreamChatGPTResponse with the whole history or activities.RejectChatRequest
+// activities.ConvertToHTML
+// activities.RespondToUser
+func ChatGPTTurn(ctx workflow.Context, input ChatGPTTurnInput) (ChatGPTTurnOutput, error) {
+	ctx = withActivityOptions(ctx, input.WorkflowActivityID)
+
+	status := SessionStatus{
+		Stage:          SessionStageAwaitingApproval,
+		ApprovalStatus: domain.RequestStatusPending,
+		HistoryLength:  len(input.History),
+	}
+	err := setStatusQueryHandler(ctx, &status)
+	if err != nil {
+		return ChatGPTTurnOutput{}, err
+	}
+	defer func() { status.Stage = SessionStageClosed }()
+
+	approvalResp, err := approveRequest(ctx, activities.GetRequestApprovalRequest{
+		ChannelID:    input.AuditLogChannelID,
+		ChatID:       input.ChatID,
+		ChatUserName: input.ChatUserName,
+		Request:      input.Request,
+		Images:       input.Images,
+		Attachments:  input.Attachments,
+		Model:        input.ChatOptions.Model,
+		Persona:      input.ChatOptions.Persona,
+		History:      input.History,
+	}, input.ApprovalPolicy, input.QuotaPolicy, input.ApprovalRules,
+		input.ModerationPolicy)
+	if err != nil {
+		return ChatGPTTurnOutput{}, err
+	}
+	status.setApprovalStatus(approvalResp)
+
+	switch approvalResp.Status {
+	case domain.RequestStatusRejected, domain.RequestStatusCanceled:
+		err = workflow.ExecuteActivity(ctx, a.RejectChatRequest, activities.RejectChatRequestRequest{
+			ChatID:        input.ChatID,
+			RejectMessage: "Follow-up: " + approvalResp.Message,
+		}).Get(ctx, nil)
+		return ChatGPTTurnOutput{
+			Status:            approvalResp.Status,
+			ApprovalMessageID: approvalResp.MessageID,
+			DecidedBy:         approvalResp.DecidedByName,
+		}, err
+	}
+
+	request, err := applyEditedRequest(ctx, input.ChatID, input.Request, approvalResp)
+	if err != nil {
+		return ChatGPTTurnOutput{}, err
+	}
+
+	next := domain.ChatMessage{
+		Role:        domain.ChatMessageRoleUser,
+		Content:     request,
+		Images:      input.Images,
+		Attachments: input.Attachments,
+	}
+	// Only approved follow-ups spend tokens on the summary
+	history, err := fitContext(ctx, &status, input.ChatID, input.ChatOptions, input.History, next)
+	if err != nil {
+		return ChatGPTTurnOutput{}, err
+	}
+	history = append(history[:len(history):len(history)], next)
+	status.HistoryLength = len(history)
+	responses, messages, err := answerConversation(ctx, &status, input.ChatID, input.ChatOptions, history)
+	if err != nil {
+		return ChatGPTTurnOutput{}, err
+	}
+
+	return ChatGPTTurnOutput{
+		Status:            domain.RequestStatusApproved,
+		ApprovalMessageID: approvalResp.MessageID,
+		Request:           request,
+		Responses:         responses,
+		History:           append(history, responses...),
+		Messages:          messages,
+		DecidedBy:         approvalResp.DecidedByName,
+		Usage:             status.Usage,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// maxSummaryTokens is reserved in the context for the summary of the dropped turns.
+const maxSummaryTokens = 600
+
+// fitContext drops the oldest turns of the history so that the next message fits the context policy
+// of the options. With domain.ContextStrategySummarize the dropped turns are replaced
+// by their summary made by activities.SummarizeHistory. The previous summary is summarized again
+// with the next dropped turns.
+func fitContext(ctx workflow.Context, status *SessionStatus, chatID int64, opts domain.ChatOptions,
+	history []domain.ChatMessage, next domain.ChatMessage,
+) ([]domain.ChatMessage, error) {
+	policy := opts.Context
+	if policy.MaxTokens <= 0 {
+		return history, nil
+	}
+	summary, dropped, kept := splitContext(opts, history, next)
+	if len(dropped) == 0 || policy.Strategy != domain.ContextStrategySummarize {
+		return append(summary, kept...), nil
+	}
+
+	model := policy.SummaryModel
+	if model == "" {
+		model = opts.Model
+	}
+	var resp activities.SummarizeHistoryResponse
+	err := workflow.ExecuteActivity(gptActivityCtx(auxiliaryActivityCtx(ctx)), a.SummarizeHistory, activities.SummarizeHistoryRequest{
+		ChatID:   chatID,
+		Model:    model,
+		Messages: append(summary, dropped...),
+	}).Get(ctx, &resp)
+	if err != nil {
+		return nil, notifyGPTFailure(ctx, chatID, err)
+	}
+	status.Usage.Add(resp.Usage)
+	return append([]domain.ChatMessage{resp.Summary}, kept...), nil
+}
+
+// splitContext splits the history into the previous summary, the oldest turns to drop
+// and the turns kept with the next message within the context policy of the options.
+func splitContext(opts domain.ChatOptions, history []domain.ChatMessage, next domain.ChatMessage,
+) (summary, dropped, kept []domain.ChatMessage) {
+	budget := opts.Context.MaxTokens - domain.EstimateTextTokens(opts.SystemPrompt)
+	if opts.Context.Strategy == domain.ContextStrategySummarize {
+		budget -= maxSummaryTokens
+		// The summary is the only system message of the history, it has its own reserve
+		if len(history) > 0 && history[0].Role == domain.ChatMessageRoleSystem {
+			summary, history = history[:1:1], history[1:]
+		}
+	}
+	dropped, kept = domain.DropOldestTurns(append(history[:len(history):len(history)], next), budget)
+	return summary, dropped, kept[:len(kept)-1]
+}
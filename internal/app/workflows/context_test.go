@@ -0,0 +1,85 @@
+package workflows
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestSplitContext(t *testing.T) {
+	// message of 13 estimated tokens: 4 of the overhead and 9 of the content
+	message := func(role, name string) domain.ChatMessage {
+		return domain.ChatMessage{Role: role, Content: name + strings.Repeat(".", 36-len(name))}
+	}
+	u1, a1 := message(domain.ChatMessageRoleUser, "u1"), message(domain.ChatMessageRoleAssistant, "a1")
+	u2, a2 := message(domain.ChatMessageRoleUser, "u2"), message(domain.ChatMessageRoleAssistant, "a2")
+	next := message(domain.ChatMessageRoleUser, "next")
+	call := message(domain.ChatMessageRoleAssistant, "call")
+	result := message(domain.ChatMessageRoleTool, "result")
+	summary := message(domain.ChatMessageRoleSystem, "summary")
+	huge := domain.ChatMessage{Role: domain.ChatMessageRoleUser, Content: strings.Repeat(".", 1000)}
+
+	truncate := func(maxTokens int) domain.ChatOptions {
+		return domain.ChatOptions{Context: domain.ContextPolicy{Strategy: domain.ContextStrategyTruncate, MaxTokens: maxTokens}}
+	}
+	summarize := func(maxTokens int) domain.ChatOptions {
+		return domain.ChatOptions{Context: domain.ContextPolicy{Strategy: domain.ContextStrategySummarize, MaxTokens: maxTokens}}
+	}
+	withPrompt := truncate(45)
+	withPrompt.SystemPrompt = strings.Repeat(".", 40)
+
+	tests := []struct {
+		name    string
+		opts    domain.ChatOptions
+		history []domain.ChatMessage
+		next    domain.ChatMessage
+		summary []domain.ChatMessage
+		dropped []domain.ChatMessage
+		kept    []domain.ChatMessage
+	}{
+		{"empty history", truncate(50), nil, next, nil, nil, []domain.ChatMessage{}},
+		{"fits the budget", truncate(100), []domain.ChatMessage{u1, a1, u2, a2}, next,
+			nil, []domain.ChatMessage{}, []domain.ChatMessage{u1, a1, u2, a2}},
+		{"drops the oldest turn", truncate(50), []domain.ChatMessage{u1, a1, u2, a2}, next,
+			nil, []domain.ChatMessage{u1, a1}, []domain.ChatMessage{u2, a2}},
+		{"drops tool calls with their turn", truncate(60), []domain.ChatMessage{u1, call, result, a1, u2, a2}, next,
+			nil, []domain.ChatMessage{u1, call, result, a1}, []domain.ChatMessage{u2, a2}},
+		{"system prompt takes the budget", withPrompt, []domain.ChatMessage{u1, a1, u2, a2}, next,
+			nil, []domain.ChatMessage{u1, a1, u2, a2}, []domain.ChatMessage{}},
+		{"next message over the budget", truncate(50), []domain.ChatMessage{u1, a1}, huge,
+			nil, []domain.ChatMessage{u1, a1}, []domain.ChatMessage{}},
+		{"summary reserve", summarize(maxSummaryTokens + 50), []domain.ChatMessage{u1, a1, u2, a2}, next,
+			nil, []domain.ChatMessage{u1, a1}, []domain.ChatMessage{u2, a2}},
+		{"previous summary is kept apart", summarize(maxSummaryTokens + 50), []domain.ChatMessage{summary, u1, a1, u2, a2}, next,
+			[]domain.ChatMessage{summary}, []domain.ChatMessage{u1, a1}, []domain.ChatMessage{u2, a2}},
+		{"previous summary fits", summarize(maxSummaryTokens + 100), []domain.ChatMessage{summary, u1, a1}, next,
+			[]domain.ChatMessage{summary}, []domain.ChatMessage{}, []domain.ChatMessage{u1, a1}},
+	}
+	for _, tt := range tests {
+		history := slices.Clone(tt.history)
+		summary, dropped, kept := splitContext(tt.opts, history, tt.next)
+		if got, expected := contents(summary), contents(tt.summary); got != expected {
+			t.Errorf("%s: summary = %s, expected %s", tt.name, got, expected)
+		}
+		if got, expected := contents(dropped), contents(tt.dropped); got != expected {
+			t.Errorf("%s: dropped = %s, expected %s", tt.name, got, expected)
+		}
+		if got, expected := contents(kept), contents(tt.kept); got != expected {
+			t.Errorf("%s: kept = %s, expected %s", tt.name, got, expected)
+		}
+		if !slices.EqualFunc(history, tt.history, func(a, b domain.ChatMessage) bool { return a.Content == b.Content }) {
+			t.Errorf("%s: history is modified", tt.name)
+		}
+	}
+}
+
+func contents(msgs []domain.ChatMessage) string {
+	names := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		names = append(names, strings.TrimRight(msg.Content, "."))
+	}
+	return fmt.Sprint(names)
+}
@@ -0,0 +1,39 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Search attributes of ChatGTPSession used to find the open session of the chat.
+var (
+	ChatIDSearchAttribute         = temporal.NewSearchAttributeKeyInt64("ChatID")
+	ChatUserNameSearchAttribute   = temporal.NewSearchAttributeKeyKeyword("ChatUserName")
+	InConversationSearchAttribute = temporal.NewSearchAttributeKeyBool("ChatInConversation")
+	// SessionVersionSearchAttribute is the SessionVersion the session was started with.
+	SessionVersionSearchAttribute = temporal.NewSearchAttributeKeyInt64("ChatSessionVersion")
+)
+
+// RegisterSearchAttributes adds the session search attributes to the namespace if they are missing.
+func RegisterSearchAttributes(ctx context.Context, cli client.Client) error {
+	_, err := cli.OperatorService().AddSearchAttributes(ctx, &operatorservice.AddSearchAttributesRequest{
+		Namespace: client.DefaultNamespace,
+		SearchAttributes: map[string]enums.IndexedValueType{
+			ChatIDSearchAttribute.GetName():         enums.INDEXED_VALUE_TYPE_INT,
+			ChatUserNameSearchAttribute.GetName():   enums.INDEXED_VALUE_TYPE_KEYWORD,
+			InConversationSearchAttribute.GetName(): enums.INDEXED_VALUE_TYPE_BOOL,
+			SessionVersionSearchAttribute.GetName(): enums.INDEXED_VALUE_TYPE_INT,
+		},
+	})
+	var alreadyExists *serviceerror.AlreadyExists
+	if errors.As(err, &alreadyExists) {
+		return nil
+	}
+	return err
+}
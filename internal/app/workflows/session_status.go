@@ -0,0 +1,48 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+// SessionStatusQuery returns the SessionStatus of ChatGTPSession and ChatGPTTurn.
+const SessionStatusQuery = "session-status-query"
+
+type SessionStage string
+
+const (
+	SessionStageAwaitingApproval     SessionStage = "awaiting approval"
+	SessionStageCallingGPT           SessionStage = "calling GPT"
+	SessionStageCallingTools         SessionStage = "calling tools"
+	SessionStageResponding           SessionStage = "responding"
+	SessionStageAwaitingGroupMessage SessionStage = "awaiting group message"
+	SessionStageAwaitingFollowUp     SessionStage = "awaiting follow-up"
+	SessionStageFollowUp             SessionStage = "processing follow-up"
+	SessionStageClosed               SessionStage = "closed"
+)
+
+type SessionStatus struct {
+	Stage          SessionStage
+	ApprovalStatus domain.RequestStatus
+	// DecidedBy is the approver of the last request, empty if it was resolved automatically.
+	DecidedBy     string
+	Turns         int
+	HistoryLength int
+	Usage         domain.TokenUsage
+	// TurnWorkflowID is the ChatGPTTurn handling the current follow-up, its status tells the stage.
+	TurnWorkflowID string
+}
+
+func setStatusQueryHandler(ctx workflow.Context, status *SessionStatus) error {
+	return workflow.SetQueryHandler(ctx, SessionStatusQuery, func() (SessionStatus, error) {
+		return *status, nil
+	})
+}
+
+// setApprovalStatus records the approval decision of the current request.
+func (s *SessionStatus) setApprovalStatus(resp activities.GetRequestApprovalResponse) {
+	s.ApprovalStatus = resp.Status
+	s.DecidedBy = resp.DecidedByName
+}
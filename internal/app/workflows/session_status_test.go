@@ -0,0 +1,95 @@
+package workflows
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestSessionStatusQuery(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+
+	// The quota within the budget sends the follow-up to approvers
+	env.OnActivity(a.ModerateContent, mock.Anything, mock.Anything).
+		Return(activities.ModerateContentResponse{Redacted: "next"}, nil)
+	env.OnActivity(a.CheckQuota, mock.Anything, mock.Anything).
+		Return(activities.CheckQuotaResponse{Status: domain.QuotaStatusWithin}, nil)
+	env.OnActivity(a.NotifyUser, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.GetRequestApproval, mock.Anything, mock.Anything).After(time.Minute).
+		Return(activities.GetRequestApprovalResponse{
+			MessageID:     10,
+			Message:       "Request rejected",
+			Status:        domain.RequestStatusRejected,
+			DecidedBy:     2,
+			DecidedByName: "approver",
+		}, nil)
+	env.OnActivity(a.RejectChatRequest, mock.Anything, mock.Anything).Return(nil)
+
+	queryStatus := func() SessionStatus {
+		t.Helper()
+		value, err := env.QueryWorkflow(SessionStatusQuery)
+		if err != nil {
+			t.Fatalf("query status: %v", err)
+		}
+		var status SessionStatus
+		if err = value.Get(&status); err != nil {
+			t.Fatalf("decode status: %v", err)
+		}
+		return status
+	}
+	queryPending := func() PendingApproval {
+		t.Helper()
+		value, err := env.QueryWorkflow(PendingApprovalQuery)
+		if err != nil {
+			t.Fatalf("query pending approval: %v", err)
+		}
+		var pending PendingApproval
+		if err = value.Get(&pending); err != nil {
+			t.Fatalf("decode pending approval: %v", err)
+		}
+		return pending
+	}
+
+	env.RegisterDelayedCallback(func() {
+		status := queryStatus()
+		if status.Stage != SessionStageAwaitingApproval || status.ApprovalStatus != domain.RequestStatusPending ||
+			status.HistoryLength != 2 {
+			t.Errorf("status while waiting for approvers = %+v", status)
+		}
+		if pending := queryPending(); !pending.Pending || pending.Quorum != nil {
+			t.Errorf("pending approval while waiting for approvers = %+v", pending)
+		}
+	}, 30*time.Second)
+
+	env.ExecuteWorkflow(ChatGPTTurn, ChatGPTTurnInput{
+		ChatID:       1,
+		ChatUserName: "user",
+		Request:      "next",
+		History: []domain.ChatMessage{
+			{Role: domain.ChatMessageRoleUser, Content: "first"},
+			{Role: domain.ChatMessageRoleAssistant, Content: "answer"},
+		},
+	})
+	if !env.IsWorkflowCompleted() {
+		t.Fatal("workflow is not completed")
+	}
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+
+	status := queryStatus()
+	if status.Stage != SessionStageClosed || status.ApprovalStatus != domain.RequestStatusRejected ||
+		status.DecidedBy != "approver" {
+		t.Errorf("status after the decision = %+v", status)
+	}
+	if pending := queryPending(); pending.Pending {
+		t.Errorf("pending approval after the decision = %+v", pending)
+	}
+	env.AssertExpectations(t)
+}
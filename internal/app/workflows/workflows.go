@@ -1,7 +1,37 @@
 package workflows
 
 import (
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
 	"github.com/xenking/managed-tg-gpt-chat/internal/app/activities"
 )
 
 var a *activities.Activities
+
+func withActivityOptions(ctx workflow.Context, activityID string) workflow.Context {
+	return workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout:    time.Hour,
+		ScheduleToCloseTimeout: 10 * time.Minute,
+		ActivityID:             activityID,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval: 5 * time.Second,
+			MaximumAttempts: 10,
+		},
+	})
+}
+
+// gptActivityCtx backs off the retries of Chat GPT requests. The activities stop the retries
+// of the requests the provider rejects and delay the rate limited ones as the provider asks.
+func gptActivityCtx(ctx workflow.Context) workflow.Context {
+	opts := workflow.GetActivityOptions(ctx)
+	opts.RetryPolicy = &temporal.RetryPolicy{
+		InitialInterval:    5 * time.Second,
+		BackoffCoefficient: 2,
+		MaximumInterval:    time.Minute,
+		MaximumAttempts:    6,
+	}
+	return workflow.WithActivityOptions(ctx, opts)
+}
@@ -1,5 +1,10 @@
 package domain
 
+import (
+	"context"
+	"time"
+)
+
 type RequestStatus string
 
 const (
@@ -8,3 +13,77 @@ const (
 	RequestStatusPending  RequestStatus = "pending"
 	RequestStatusCanceled RequestStatus = "canceled"
 )
+
+// ApprovalPolicy is the approval SLA of a request.
+type ApprovalPolicy struct {
+	// ReminderInterval between reminders posted to the audit channel, zero disables reminders.
+	ReminderInterval time.Duration
+	// EscalateAfter sends the request to EscalationChatID as well, zero disables escalation.
+	// The escalation chat must be a channel or a supergroup.
+	EscalateAfter    time.Duration
+	EscalationChatID int64
+	// Deadline after which the request is resolved with DeadlineStatus.
+	Deadline       time.Duration
+	DeadlineStatus RequestStatus
+	// Quorum replaces the first approver decision with N-of-M votes.
+	Quorum *QuorumPolicy
+}
+
+// QuorumPolicy requires Required of Approvers to approve a request.
+type QuorumPolicy struct {
+	Name      string
+	Approvers []int64
+	Required  int
+	// Keywords select the policy for requests that contain any of them.
+	Keywords []string
+}
+
+// MatchQuorumPolicy returns the first policy with a keyword found in the request.
+func MatchQuorumPolicy(policies []QuorumPolicy, request string) *QuorumPolicy {
+	for i, policy := range policies {
+		if containsAnyKeyword(request, policy.Keywords) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+func (p *QuorumPolicy) IsApprover(userID int64) bool {
+	for _, approver := range p.Approvers {
+		if approver == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalVote is a single approver decision on a request with QuorumPolicy.
+type ApprovalVote struct {
+	VoterID   int64
+	VoterName string
+	Status    RequestStatus
+}
+
+// TallyVotes returns the request status once the quorum is reached or can no longer be reached.
+func (p *QuorumPolicy) TallyVotes(votes []ApprovalVote) (approved, rejected int, status RequestStatus) {
+	for _, vote := range votes {
+		switch vote.Status {
+		case RequestStatusApproved:
+			approved++
+		case RequestStatusRejected:
+			rejected++
+		}
+	}
+	switch {
+	case approved >= p.Required:
+		return approved, rejected, RequestStatusApproved
+	case len(p.Approvers)-rejected < p.Required:
+		return approved, rejected, RequestStatusRejected
+	}
+	return approved, rejected, RequestStatusPending
+}
+
+// ApproverRegistry decides who may approve or reject requests posted to the chat.
+type ApproverRegistry interface {
+	IsApprover(ctx context.Context, chatID, userID int64) (bool, error)
+}
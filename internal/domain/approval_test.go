@@ -0,0 +1,70 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestMatchQuorumPolicy(t *testing.T) {
+	policies := []domain.QuorumPolicy{
+		{Name: "production", Keywords: []string{"prod", "production", "drop table"}},
+		{Name: "billing", Keywords: []string{"invoice"}},
+	}
+	tests := []struct {
+		request string
+		policy  string
+	}{
+		{"How do I restart the prod cluster?", "production"},
+		{"Deploy to PRODUCTION now", "production"},
+		{"prod-db is down", "production"},
+		{"Why does it DROP  TABLE users?", "production"},
+		{"Where is the invoice?", "billing"},
+		{"Describe the product roadmap", ""},
+		{"How to reproduce the bug", ""},
+		{"Tips for productivity", ""},
+		{"drop the table", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		policy := domain.MatchQuorumPolicy(policies, tt.request)
+		name := ""
+		if policy != nil {
+			name = policy.Name
+		}
+		if name != tt.policy {
+			t.Errorf("MatchQuorumPolicy(%q) = %q, expected %q", tt.request, name, tt.policy)
+		}
+	}
+}
+
+func TestTallyVotes(t *testing.T) {
+	policy := domain.QuorumPolicy{Approvers: []int64{1, 2, 3}, Required: 2}
+	approve := func(id int64) domain.ApprovalVote {
+		return domain.ApprovalVote{VoterID: id, Status: domain.RequestStatusApproved}
+	}
+	reject := func(id int64) domain.ApprovalVote {
+		return domain.ApprovalVote{VoterID: id, Status: domain.RequestStatusRejected}
+	}
+	tests := []struct {
+		name               string
+		votes              []domain.ApprovalVote
+		approved, rejected int
+		status             domain.RequestStatus
+	}{
+		{"no votes", nil, 0, 0, domain.RequestStatusPending},
+		{"one approval", []domain.ApprovalVote{approve(1)}, 1, 0, domain.RequestStatusPending},
+		{"quorum reached", []domain.ApprovalVote{approve(1), approve(2)}, 2, 0, domain.RequestStatusApproved},
+		{"one rejection", []domain.ApprovalVote{reject(1)}, 0, 1, domain.RequestStatusPending},
+		{"split votes", []domain.ApprovalVote{approve(1), reject(2)}, 1, 1, domain.RequestStatusPending},
+		{"quorum unreachable", []domain.ApprovalVote{reject(1), reject(2)}, 0, 2, domain.RequestStatusRejected},
+		{"approved after rejection", []domain.ApprovalVote{reject(1), approve(2), approve(3)}, 2, 1, domain.RequestStatusApproved},
+	}
+	for _, tt := range tests {
+		approved, rejected, status := policy.TallyVotes(tt.votes)
+		if approved != tt.approved || rejected != tt.rejected || status != tt.status {
+			t.Errorf("%s: TallyVotes = %d, %d, %s, expected %d, %d, %s",
+				tt.name, approved, rejected, status, tt.approved, tt.rejected, tt.status)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedAttachment is returned for documents without extractable text, e.g. archives.
+var ErrUnsupportedAttachment = errors.New("unsupported attachment")
+
+// Attachment is the document sent with the request, e.g. a source file, a log or a PDF.
+// Only the extracted text is kept, the model gets it as the context of the request.
+type Attachment struct {
+	FileName string
+	MimeType string
+	Size     int64
+	Text     string
+	// Truncated is set if the text was cut to the size limit.
+	Truncated bool
+}
+
+// Context presents the attachment to the model.
+func (a Attachment) Context() string {
+	note := ""
+	if a.Truncated {
+		note = " (truncated)"
+	}
+	return fmt.Sprintf("Attached file %s%s:\n```\n%s\n```", a.FileName, note, a.Text)
+}
+
+// TextExtractor pulls out the text of the attached documents.
+type TextExtractor interface {
+	// Extract returns ErrUnsupportedAttachment if the document has no text.
+	Extract(fileName, mimeType string, data []byte) (string, error)
+}
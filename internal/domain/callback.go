@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// Activity IDs of the pending approval activities referenced by callback data.
+const (
+	ApprovalActivityID           = "approval"
+	EscalationApprovalActivityID = "approval-escalation"
+)
+
+// ApprovalCallback references the pending approval activity from the keyboard callback data.
+type ApprovalCallback struct {
+	WorkflowID string
+	ActivityID string
+	Status     RequestStatus
+	// Quorum marks requests decided by the votes of named approvers.
+	Quorum    bool
+	ExpiresAt time.Time
+}
+
+// ApprovalCallbackCodec signs callback data so that it can't be forged.
+// The callback carries everything needed to complete the approval, it replaces the storage
+// of activity task tokens: no server state must survive restarts to decide on pending requests.
+type ApprovalCallbackCodec interface {
+	Encode(cb ApprovalCallback) (string, error)
+	Decode(data string) (ApprovalCallback, error)
+}
@@ -0,0 +1,70 @@
+package domain
+
+type ContextStrategy string
+
+const (
+	// ContextStrategyTruncate drops the oldest turns of the conversation.
+	ContextStrategyTruncate ContextStrategy = "truncate"
+	// ContextStrategySummarize replaces the oldest turns with their summary made by the cheap model.
+	ContextStrategySummarize ContextStrategy = "summarize"
+)
+
+// ContextPolicy keeps the conversation within the context window of the model.
+// The system prompt and the current turn are never cut.
+type ContextPolicy struct {
+	Strategy ContextStrategy
+	// MaxTokens is the budget of the prompt with the system prompt, zero disables the policy.
+	MaxTokens int
+	// SummaryModel summarizes the dropped turns, the model of the request is used if empty.
+	SummaryModel string
+}
+
+// Token estimates of the content the text size doesn't tell.
+const (
+	messageOverheadTokens = 4
+	imageTokens           = 765
+)
+
+// EstimateTextTokens estimates the tokens of the text without the tokenizer of the model.
+// English takes about 4 bytes per token and other languages less, so the estimate errs on the safe side.
+func EstimateTextTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// EstimateTokens estimates the tokens of the messages, images are counted at the high detail.
+func EstimateTokens(msgs ...ChatMessage) int {
+	tokens := 0
+	for _, msg := range msgs {
+		tokens += messageOverheadTokens + EstimateTextTokens(msg.Content) + len(msg.Images)*imageTokens
+		for _, attachment := range msg.Attachments {
+			tokens += messageOverheadTokens + EstimateTextTokens(attachment.Context())
+		}
+		if len(msg.Knowledge) > 0 {
+			tokens += messageOverheadTokens + EstimateTextTokens(KnowledgeContext(msg.Knowledge))
+		}
+		for _, call := range msg.ToolCalls {
+			tokens += EstimateTextTokens(call.Name) + EstimateTextTokens(call.Arguments)
+		}
+	}
+	return tokens
+}
+
+// DropOldestTurns drops the oldest turns until the history fits the budget.
+// A turn starts with the user message and holds the answer with its tool calls, so they are dropped together.
+// The last turn is always kept.
+func DropOldestTurns(history []ChatMessage, budget int) (dropped, kept []ChatMessage) {
+	tokens := EstimateTokens(history...)
+	start := 0
+	for tokens > budget {
+		next := start + 1
+		for next < len(history) && history[next].Role != ChatMessageRoleUser {
+			next++
+		}
+		if next >= len(history) {
+			break
+		}
+		tokens -= EstimateTokens(history[start:next]...)
+		start = next
+	}
+	return history[:start], history[start:]
+}
@@ -5,17 +5,80 @@ import (
 )
 
 type GPTClient interface {
-	Ask(ctx context.Context, msgs ...ChatMessage) (*ChatAnswer, error)
+	Ask(ctx context.Context, opts ChatOptions, msgs ...ChatMessage) (*ChatAnswer, error)
+	// AskStream calls onChunk with the answer generated so far while the completion is streamed.
+	AskStream(ctx context.Context, opts ChatOptions, onChunk func(content string), msgs ...ChatMessage) (*ChatAnswer, error)
+}
+
+// ChatOptions are chosen by the user per request, empty values fall back to the client defaults.
+type ChatOptions struct {
+	Model string
+	// Persona is the name of the persona, its SystemPrompt is resolved when the request is made
+	// and kept with the request so that the answer doesn't depend on later config changes.
+	Persona      string
+	SystemPrompt string
+	// Context is the context policy of the persona.
+	Context ContextPolicy
+	// Tools are offered to the model, the worker sets them from its registry.
+	Tools []ToolDefinition
+	// NoTools makes the model answer without calling tools, e.g. once the tool call limit is reached.
+	NoTools bool
+}
+
+// Persona is a named system prompt users pick with /persona.
+type Persona struct {
+	Name         string
+	Description  string
+	SystemPrompt string
+	// Context overrides the default context policy if its strategy is set.
+	Context ContextPolicy
+}
+
+// FindPersona returns the persona by name.
+func FindPersona(personas []Persona, name string) (Persona, bool) {
+	for _, persona := range personas {
+		if persona.Name == name {
+			return persona, true
+		}
+	}
+	return Persona{}, false
 }
 
 type ChatAnswer struct {
 	Request  []ChatMessage
 	Response []ChatMessage
+	Usage    TokenUsage
 }
 
 type ChatMessage struct {
 	Role    string
 	Content string
+	// Images are attached to the user message, e.g. a screenshot with the question in the caption.
+	Images []ChatImage
+	// Attachments are sent to the model as the context of the user message.
+	Attachments []Attachment
+	// Knowledge is retrieved from the internal docs for the user message.
+	Knowledge []KnowledgeChunk
+	// ToolCalls are requested by the assistant message, ToolCallID answers the call by the tool message.
+	ToolCalls  []ToolCall
+	ToolCallID string
+}
+
+// ChatImage is the photo sent to Telegram. Workflows keep only the FileID,
+// the URL with the image data is resolved right before the request is sent to the model.
+type ChatImage struct {
+	FileID string
+	URL    string
+}
+
+// HasImages reports whether any of the messages has images.
+func HasImages(msgs ...ChatMessage) bool {
+	for _, msg := range msgs {
+		if len(msg.Images) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 const (
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// GPTErrorKind classifies the failures reported by the GPT provider.
+type GPTErrorKind string
+
+const (
+	GPTErrorRateLimited     GPTErrorKind = "rate_limited"
+	GPTErrorUnavailable     GPTErrorKind = "unavailable"
+	GPTErrorUnauthorized    GPTErrorKind = "unauthorized"
+	GPTErrorQuotaExceeded   GPTErrorKind = "quota_exceeded"
+	GPTErrorContextLength   GPTErrorKind = "context_length_exceeded"
+	GPTErrorContentFiltered GPTErrorKind = "content_filtered"
+	GPTErrorInvalidRequest  GPTErrorKind = "invalid_request"
+)
+
+var gptErrorMessages = map[GPTErrorKind]string{
+	GPTErrorRateLimited:     "Chat GPT is overloaded with requests, try again in a few minutes.",
+	GPTErrorUnavailable:     "Chat GPT is unavailable, try again later.",
+	GPTErrorUnauthorized:    "The bot is not authorized to use Chat GPT, please tell the administrators.",
+	GPTErrorQuotaExceeded:   "The Chat GPT account ran out of credits, please tell the administrators.",
+	GPTErrorContextLength:   "The conversation is too long for the model, start a new one or choose a model with a larger context.",
+	GPTErrorContentFiltered: "Chat GPT refused to answer the request because of its content policy.",
+	GPTErrorInvalidRequest:  "Chat GPT rejected the request, try to rephrase it or choose another model.",
+}
+
+// Retryable tells whether the same request may succeed later.
+func (k GPTErrorKind) Retryable() bool {
+	return slices.Contains([]GPTErrorKind{GPTErrorRateLimited, GPTErrorUnavailable}, k)
+}
+
+// UserMessage explains the failure to the user, unknown kinds are shown as unavailability.
+func (k GPTErrorKind) UserMessage() string {
+	if msg, ok := gptErrorMessages[k]; ok {
+		return msg
+	}
+	return gptErrorMessages[GPTErrorUnavailable]
+}
+
+// GPTError is returned by GPTClient when the provider rejects the request.
+type GPTError struct {
+	Kind GPTErrorKind
+	// RetryAfter is the delay the provider asked for before the next attempt, zero if not given.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *GPTError) Error() string {
+	return string(e.Kind) + ": " + e.Err.Error()
+}
+
+func (e *GPTError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Embedder turns texts into vectors, similar texts get close vectors.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// KnowledgeBase searches the internal docs for the request.
+type KnowledgeBase interface {
+	// Search returns up to k chunks most similar to the query.
+	Search(ctx context.Context, query string, k int) ([]KnowledgeChunk, error)
+}
+
+// KnowledgeChunk is the piece of the internal docs retrieved for the request, Source is the file path.
+type KnowledgeChunk struct {
+	Source string
+	Text   string
+	Score  float32
+}
+
+// KnowledgeContext presents the chunks to the model, asking to cite their sources.
+func KnowledgeContext(chunks []KnowledgeChunk) string {
+	var sb strings.Builder
+	sb.WriteString("Excerpts from the internal docs. Use them if they are relevant to the next message " +
+		"and cite the source file of every fact taken from them, e.g. [docs/setup.md].")
+	for _, chunk := range chunks {
+		fmt.Fprintf(&sb, "\n\n[%s]\n%s", chunk.Source, chunk.Text)
+	}
+	return sb.String()
+}
+
+// ChunkText splits the text into chunks of up to size bytes at paragraph boundaries,
+// long paragraphs are split at line boundaries and long lines are cut at spaces or rune boundaries.
+func ChunkText(text string, size int) []string {
+	var (
+		chunks  []string
+		current strings.Builder
+	)
+	flush := func() {
+		if chunk := strings.TrimSpace(current.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+	}
+	add := func(part, sep string) {
+		if current.Len() > 0 && current.Len()+len(sep)+len(part) > size {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString(sep)
+		}
+		current.WriteString(part)
+	}
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if len(paragraph) <= size {
+			add(paragraph, "\n\n")
+			continue
+		}
+		for _, line := range strings.Split(paragraph, "\n") {
+			for len(line) > size {
+				cut := strings.LastIndexByte(line[:size], ' ')
+				if cut <= 0 {
+					cut = runeCut(line, size)
+				}
+				add(line[:cut], "\n")
+				line = line[cut:]
+			}
+			add(line, "\n")
+		}
+	}
+	flush()
+	return chunks
+}
+
+// runeCut returns the last rune boundary of the line up to size bytes,
+// or the end of the first rune if it is longer than size.
+func runeCut(line string, size int) int {
+	cut := size
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	if cut > 0 {
+		return cut
+	}
+	_, cut = utf8.DecodeRuneInString(line)
+	return cut
+}
@@ -0,0 +1,36 @@
+package domain_test
+
+import (
+	"slices"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		size     int
+		expected []string
+	}{
+		{"paragraphs fit together", "one\n\ntwo", 10, []string{"one\n\ntwo"}},
+		{"paragraphs are split", "first one\n\nsecond one", 12, []string{"first one", "second one"}},
+		{"long line is cut at spaces", "alpha beta gamma", 11, []string{"alpha beta", "gamma"}},
+		{"long word is cut", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"multibyte word is cut at rune boundaries", "привет", 5, []string{"пр", "ив", "ет"}},
+		{"rune longer than size", "日本", 2, []string{"日", "本"}},
+	}
+	for _, tt := range tests {
+		chunks := domain.ChunkText(tt.text, tt.size)
+		if !slices.Equal(chunks, tt.expected) {
+			t.Errorf("%s: ChunkText(%q, %d) = %q, expected %q", tt.name, tt.text, tt.size, chunks, tt.expected)
+		}
+		for _, chunk := range chunks {
+			if !utf8.ValidString(chunk) {
+				t.Errorf("%s: ChunkText(%q, %d) returned invalid UTF-8 %q", tt.name, tt.text, tt.size, chunk)
+			}
+		}
+	}
+}
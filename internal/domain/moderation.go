@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type SensitiveKind string
+
+const (
+	SensitiveKindPrivateKey SensitiveKind = "private_key"
+	SensitiveKindToken      SensitiveKind = "token"
+	SensitiveKindAPIKey     SensitiveKind = "api_key"
+	SensitiveKindEmail      SensitiveKind = "email"
+	SensitiveKindPhone      SensitiveKind = "phone"
+)
+
+// sensitivePatterns are applied in order, so that e.g. the keys inside tokens are redacted once.
+var sensitivePatterns = []struct {
+	kind SensitiveKind
+	re   *regexp.Regexp
+}{
+	{SensitiveKindPrivateKey, regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	{SensitiveKindToken, regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]+`)},  // JWT
+	{SensitiveKindToken, regexp.MustCompile(`\b\d{8,10}:[A-Za-z0-9_-]{35}\b`)},                             // Telegram bot
+	{SensitiveKindToken, regexp.MustCompile(`(?i)\b(?:bearer|token)[\s:=]+["']?[A-Za-z0-9._~+/-]{20,}=*`)}, // Authorization headers
+	{SensitiveKindAPIKey, regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},                                   // OpenAI
+	{SensitiveKindAPIKey, regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`)},                                      // AWS
+	{SensitiveKindAPIKey, regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},                                 // Google
+	{SensitiveKindAPIKey, regexp.MustCompile(`\b(?:gh[pousr]|github_pat)_[A-Za-z0-9_]{36,}\b`)},            // GitHub
+	{SensitiveKindAPIKey, regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},                            // Slack
+	{SensitiveKindEmail, regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+	// Phones need the country code or separators, bare numbers are mostly timestamps and IDs
+	{SensitiveKindPhone, regexp.MustCompile(`\+\d{1,3}[\s-]?(?:\(\d{3}\)|\d{3})[\s-]?\d{3}[\s-]?\d{2}[\s-]?\d{2}\b|` +
+		`(?:\(\d{3}\)\s?|\b\d{3}[\s-])\d{3}[\s-]\d{2}[\s-]?\d{2}\b`)},
+}
+
+// SensitiveFinding counts the sensitive data of the kind found in the text.
+type SensitiveFinding struct {
+	Kind  SensitiveKind
+	Count int
+}
+
+func (f SensitiveFinding) String() string {
+	return fmt.Sprintf("%d %s", f.Count, strings.ReplaceAll(string(f.Kind), "_", " "))
+}
+
+// RedactSensitive replaces the private keys, tokens, API keys, emails and phone numbers in the text
+// with placeholders like [redacted email].
+func RedactSensitive(text string) (string, []SensitiveFinding) {
+	var findings []SensitiveFinding
+	for _, pattern := range sensitivePatterns {
+		count := 0
+		text = pattern.re.ReplaceAllStringFunc(text, func(string) string {
+			count++
+			return "[redacted " + strings.ReplaceAll(string(pattern.kind), "_", " ") + "]"
+		})
+		if count == 0 {
+			continue
+		}
+		i := slices.IndexFunc(findings, func(f SensitiveFinding) bool { return f.Kind == pattern.kind })
+		if i < 0 {
+			findings = append(findings, SensitiveFinding{Kind: pattern.kind})
+			i = len(findings) - 1
+		}
+		findings[i].Count += count
+	}
+	return text, findings
+}
+
+// ModerationPolicy screens requests before they are posted to approvers.
+// Sensitive data is redacted in the audit channel only, the model gets the request as is.
+type ModerationPolicy struct {
+	// Block rejects requests with sensitive data of the kinds.
+	Block []SensitiveKind
+	// CheckContent sends requests to the Moderator, BlockFlagged rejects the flagged ones.
+	CheckContent bool
+	BlockFlagged bool
+}
+
+// Moderator classifies harmful content.
+type Moderator interface {
+	// Moderate returns the flagged categories of the text, none if the text is fine.
+	Moderate(ctx context.Context, text string) ([]string, error)
+}
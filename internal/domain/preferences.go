@@ -0,0 +1,14 @@
+package domain
+
+// UserPreferences are chosen by the user in the private chat and apply to the next requests.
+type UserPreferences struct {
+	Model   string
+	Persona string
+	// DefaultPersona is set by admins for the chat and used until the user picks a persona.
+	DefaultPersona string
+}
+
+type PreferencesStorage interface {
+	Get(chatID int64) (UserPreferences, bool)
+	Set(chatID int64, prefs UserPreferences) error
+}
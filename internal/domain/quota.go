@@ -0,0 +1,52 @@
+package domain
+
+// Budget limits tokens and cost, zero values are unlimited.
+type Budget struct {
+	Tokens int
+	// Cost in USD.
+	Cost float64
+}
+
+// Share returns the used share of the budget, the largest of tokens and cost.
+func (b Budget) Share(usage TokenUsage) float64 {
+	var share float64
+	if b.Tokens > 0 {
+		share = float64(usage.TotalTokens) / float64(b.Tokens)
+	}
+	if b.Cost > 0 {
+		share = max(share, usage.Cost/b.Cost)
+	}
+	return share
+}
+
+// QuotaPolicy limits the spending per user and in total.
+type QuotaPolicy struct {
+	UserDaily    Budget
+	UserMonthly  Budget
+	TotalDaily   Budget
+	TotalMonthly Budget
+	// AutoApproveBelow skips the approval while every budget is used less than the share, e.g. 0.2.
+	AutoApproveBelow float64
+	// OverQuotaStatus resolves requests over the quota: RequestStatusRejected rejects them,
+	// RequestStatusPending requires the approval even if it could be skipped.
+	OverQuotaStatus RequestStatus
+}
+
+type QuotaStatus string
+
+const (
+	QuotaStatusUnder  QuotaStatus = "under"
+	QuotaStatusWithin QuotaStatus = "within"
+	QuotaStatusOver   QuotaStatus = "over"
+)
+
+// UserQuota replaces the user budgets of the QuotaPolicy, set by admins.
+type UserQuota struct {
+	Daily   Budget
+	Monthly Budget
+}
+
+type QuotaStorage interface {
+	Get(chatID int64) (UserQuota, bool)
+	Set(chatID int64, quota UserQuota) error
+}
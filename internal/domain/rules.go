@@ -0,0 +1,138 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type RuleAction string
+
+const (
+	RuleActionApprove         RuleAction = "approve"
+	RuleActionRequireApproval RuleAction = "require-approval"
+	RuleActionReject          RuleAction = "reject"
+)
+
+// ApprovalRule resolves matching requests before they reach approvers.
+// Zero conditions match any request, the request must match all the set ones.
+type ApprovalRule struct {
+	Name   string     `json:"name"`
+	Action RuleAction `json:"action"`
+
+	UserIDs   []int64  `json:"user_ids,omitempty"`
+	MinLength int      `json:"min_length,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Keywords  []string `json:"keywords,omitempty"`
+	// HourFrom and HourTo limit the time of day to [HourFrom, HourTo) in the TimeZone, UTC by default.
+	// The window wraps midnight if HourFrom is greater than HourTo.
+	HourFrom int    `json:"hour_from,omitempty"`
+	HourTo   int    `json:"hour_to,omitempty"`
+	TimeZone string `json:"time_zone,omitempty"`
+	// MaxBudgetUsed matches users who used at most the share of their quota, e.g. 0.5.
+	MaxBudgetUsed float64 `json:"max_budget_used,omitempty"`
+	// MinBudgetUsed matches users who used at least the share of their quota.
+	MinBudgetUsed float64 `json:"min_budget_used,omitempty"`
+}
+
+// RuleRequest is the request matched against the ApprovalRule.
+type RuleRequest struct {
+	UserID  int64
+	Request string
+	Time    time.Time
+	// BudgetUsed is the largest used share of the quota budgets.
+	BudgetUsed float64
+}
+
+// Validate checks the rule loaded from the config.
+func (r ApprovalRule) Validate() error {
+	switch r.Action {
+	case RuleActionApprove, RuleActionRequireApproval, RuleActionReject:
+	default:
+		return fmt.Errorf("rule %q: unknown action %q", r.Name, r.Action)
+	}
+	if r.HourFrom < 0 || r.HourFrom > 23 || r.HourTo < 0 || r.HourTo > 24 {
+		return fmt.Errorf("rule %q: hours must be within a day", r.Name)
+	}
+	if _, err := time.LoadLocation(r.TimeZone); err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	return nil
+}
+
+func (r ApprovalRule) Match(req RuleRequest) bool {
+	if len(r.UserIDs) > 0 && !slices.Contains(r.UserIDs, req.UserID) {
+		return false
+	}
+	length := utf8.RuneCountInString(req.Request)
+	if (r.MinLength > 0 && length < r.MinLength) || (r.MaxLength > 0 && length > r.MaxLength) {
+		return false
+	}
+	if len(r.Keywords) > 0 && !containsAnyKeyword(req.Request, r.Keywords) {
+		return false
+	}
+	if r.HourFrom != r.HourTo && !r.matchHour(req.Time) {
+		return false
+	}
+	if r.MaxBudgetUsed > 0 && req.BudgetUsed > r.MaxBudgetUsed {
+		return false
+	}
+	if r.MinBudgetUsed > 0 && req.BudgetUsed < r.MinBudgetUsed {
+		return false
+	}
+	return true
+}
+
+func (r ApprovalRule) matchHour(t time.Time) bool {
+	loc, err := time.LoadLocation(r.TimeZone)
+	if err != nil {
+		// Rules are validated on load
+		loc = time.UTC
+	}
+	hour := t.In(loc).Hour()
+	if r.HourFrom < r.HourTo {
+		return hour >= r.HourFrom && hour < r.HourTo
+	}
+	return hour >= r.HourFrom || hour < r.HourTo
+}
+
+// MatchApprovalRule returns the first rule matching the request.
+func MatchApprovalRule(rules []ApprovalRule, req RuleRequest) (ApprovalRule, bool) {
+	for _, rule := range rules {
+		if rule.Match(req) {
+			return rule, true
+		}
+	}
+	return ApprovalRule{}, false
+}
+
+// containsAnyKeyword matches whole words, so that "prod" doesn't match "product".
+// Keywords of several words match the same words in a row.
+func containsAnyKeyword(request string, keywords []string) bool {
+	words := splitWords(request)
+	for _, keyword := range keywords {
+		keywordWords := splitWords(keyword)
+		if len(keywordWords) > 0 && containsWords(words, keywordWords) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func containsWords(words, sequence []string) bool {
+	for i := 0; i+len(sequence) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(sequence)], sequence) {
+			return true
+		}
+	}
+	return false
+}
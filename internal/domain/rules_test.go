@@ -0,0 +1,80 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/xenking/managed-tg-gpt-chat/internal/domain"
+)
+
+func TestMatchApprovalRuleHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.March, 15, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		rule  domain.ApprovalRule
+		time  time.Time
+		match bool
+	}{
+		{"within the day window", domain.ApprovalRule{HourFrom: 9, HourTo: 18}, at(12, 0), true},
+		{"window start is included", domain.ApprovalRule{HourFrom: 9, HourTo: 18}, at(9, 0), true},
+		{"window end is excluded", domain.ApprovalRule{HourFrom: 9, HourTo: 18}, at(18, 0), false},
+		{"last minute of the window", domain.ApprovalRule{HourFrom: 9, HourTo: 18}, at(17, 59), true},
+		{"before the day window", domain.ApprovalRule{HourFrom: 9, HourTo: 18}, at(8, 59), false},
+		{"until the end of the day", domain.ApprovalRule{HourFrom: 18, HourTo: 24}, at(23, 59), true},
+		{"night window before midnight", domain.ApprovalRule{HourFrom: 22, HourTo: 6}, at(23, 0), true},
+		{"night window at midnight", domain.ApprovalRule{HourFrom: 22, HourTo: 6}, at(0, 0), true},
+		{"night window after midnight", domain.ApprovalRule{HourFrom: 22, HourTo: 6}, at(5, 59), true},
+		{"night window end is excluded", domain.ApprovalRule{HourFrom: 22, HourTo: 6}, at(6, 0), false},
+		{"outside the night window", domain.ApprovalRule{HourFrom: 22, HourTo: 6}, at(12, 0), false},
+		{"equal hours match any time", domain.ApprovalRule{HourFrom: 5, HourTo: 5}, at(17, 0), true},
+		// 12:00 UTC is 15:00 in Moscow
+		{"time zone shifts into the window", domain.ApprovalRule{HourFrom: 14, HourTo: 18, TimeZone: "Europe/Moscow"}, at(12, 0), true},
+		{"time zone shifts out of the window", domain.ApprovalRule{HourFrom: 9, HourTo: 13, TimeZone: "Europe/Moscow"}, at(12, 0), false},
+		// 02:00 UTC is 22:00 of the previous day in New York, daylight saving time
+		{"time zone wraps into the night window", domain.ApprovalRule{HourFrom: 22, HourTo: 6, TimeZone: "America/New_York"}, at(2, 0), true},
+		{"time zone wraps out of the night window", domain.ApprovalRule{HourFrom: 22, HourTo: 6, TimeZone: "America/New_York"}, at(12, 0), false},
+		{"request time zone is ignored", domain.ApprovalRule{HourFrom: 9, HourTo: 18},
+			time.Date(2024, time.March, 15, 20, 0, 0, 0, time.FixedZone("UTC-8", -8*3600)), false},
+	}
+	for _, tt := range tests {
+		rule := tt.rule
+		rule.Name = tt.name
+		rule.Action = domain.RuleActionApprove
+		if err := rule.Validate(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		_, ok := domain.MatchApprovalRule([]domain.ApprovalRule{rule}, domain.RuleRequest{Request: "hi", Time: tt.time})
+		if ok != tt.match {
+			t.Errorf("%s: MatchApprovalRule at %s = %t, expected %t", tt.name, tt.time, ok, tt.match)
+		}
+	}
+}
+
+func TestMatchApprovalRuleOrder(t *testing.T) {
+	rules := []domain.ApprovalRule{
+		{Name: "secrets", Action: domain.RuleActionReject, Keywords: []string{"password"}},
+		{Name: "admins", Action: domain.RuleActionApprove, UserIDs: []int64{1}},
+		{Name: "short", Action: domain.RuleActionApprove, MaxLength: 10},
+		{Name: "budget", Action: domain.RuleActionRequireApproval, MinBudgetUsed: 0.8},
+	}
+	tests := []struct {
+		req  domain.RuleRequest
+		rule string
+	}{
+		{domain.RuleRequest{UserID: 1, Request: "What is my password?"}, "secrets"},
+		{domain.RuleRequest{UserID: 1, Request: "Explain the retry policy"}, "admins"},
+		{domain.RuleRequest{UserID: 2, Request: "Hello!"}, "short"},
+		{domain.RuleRequest{UserID: 2, Request: "Explain the retry policy", BudgetUsed: 0.9}, "budget"},
+		{domain.RuleRequest{UserID: 2, Request: "Explain the retry policy", BudgetUsed: 0.5}, ""},
+	}
+	for _, tt := range tests {
+		rule, ok := domain.MatchApprovalRule(rules, tt.req)
+		if ok != (tt.rule != "") || rule.Name != tt.rule {
+			t.Errorf("MatchApprovalRule(%+v) = %q, %t, expected %q", tt.req, rule.Name, ok, tt.rule)
+		}
+	}
+}
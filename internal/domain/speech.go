@@ -0,0 +1,11 @@
+package domain
+
+import (
+	"context"
+)
+
+// SpeechToText transcribes voice messages into request text.
+type SpeechToText interface {
+	// Transcribe returns the text of the audio, the file name hints the audio format, e.g. voice.ogg.
+	Transcribe(ctx context.Context, audio []byte, fileName string) (string, error)
+}
@@ -9,13 +9,21 @@ import (
 
 type TelegramClient interface {
 	SendMessage(ctx context.Context, chatID int64, message string) error
+	SendMessageWithResult(ctx context.Context, chatID int64, message string) (*TelegramMessage, error)
 	SetBotCommands(ctx context.Context, commands []BotCommand) error
 	EditMessage(ctx context.Context, chatID int64, msg TelegramMessage) error
 
 	SendMessageHTML(ctx context.Context, chatID int64, message string) error
+	SendMessageHTMLWithResult(ctx context.Context, chatID int64, message string) (*TelegramMessage, error)
 	SendMessageHTMLWithInlineKeyboard(ctx context.Context, chatID int64, message string, buttons []KeyboardButton) (*TelegramMessage, error)
 	EditMessageHTML(ctx context.Context, chatID int64, msg TelegramMessage) error
+	EditMessageHTMLWithInlineKeyboard(ctx context.Context, chatID int64, msg TelegramMessage, buttons []KeyboardButton) error
 	ReplyToMessageHTML(ctx context.Context, chatID int64, messageID int, message string) error
+	ReplyToMessageWithPhoto(ctx context.Context, chatID int64, messageID int, fileID string, caption string) error
+	// DownloadFile returns the content of the file sent to the bot.
+	DownloadFile(ctx context.Context, fileID string) ([]byte, error)
+
+	AnswerCallbackQuery(ctx context.Context, callbackID string, text string, alert bool) error
 }
 
 type TelegramRoute interface {
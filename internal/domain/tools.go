@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Tool is the Go function exposed to the model.
+type Tool interface {
+	Definition() ToolDefinition
+	// Call runs the tool with the JSON arguments generated by the model.
+	// The returned error is shown to the model, so it may retry with other arguments.
+	Call(ctx context.Context, arguments string) (string, error)
+}
+
+// ToolDefinition describes the tool to the model, Parameters is the JSON schema of the arguments.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// ToolCall is the call of the tool requested by the model.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ToolRegistry holds the tools available to the model.
+type ToolRegistry struct {
+	tools map[string]Tool
+	order []string
+}
+
+func NewToolRegistry(tools ...Tool) *ToolRegistry {
+	r := &ToolRegistry{tools: make(map[string]Tool)}
+	for _, tool := range tools {
+		name := tool.Definition().Name
+		if _, ok := r.tools[name]; !ok {
+			r.order = append(r.order, name)
+		}
+		r.tools[name] = tool
+	}
+	return r
+}
+
+// Definitions returns the definitions of the tools in the order of registration.
+func (r *ToolRegistry) Definitions() []ToolDefinition {
+	if r == nil {
+		return nil
+	}
+	definitions := make([]ToolDefinition, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.tools[name].Definition())
+	}
+	return definitions
+}
+
+func (r *ToolRegistry) Call(ctx context.Context, call ToolCall) (string, error) {
+	if r == nil || r.tools[call.Name] == nil {
+		return "", fmt.Errorf("unknown tool %q", call.Name)
+	}
+	return r.tools[call.Name].Call(ctx, call.Arguments)
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TokenUsage is the number of tokens billed for Chat GPT requests and their estimated cost.
+type TokenUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	// Cost is estimated in USD by the PriceTable.
+	Cost float64
+}
+
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+func (u TokenUsage) String() string {
+	return fmt.Sprintf("%d tokens (prompt %d, completion %d), $%.4f",
+		u.TotalTokens, u.PromptTokens, u.CompletionTokens, u.Cost)
+}
+
+// ModelPrice is the price in USD per million tokens.
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// PriceTable maps models to their prices.
+type PriceTable map[string]ModelPrice
+
+// Cost estimates the cost of the usage, unknown models are free.
+func (t PriceTable) Cost(model string, usage TokenUsage) float64 {
+	price := t[model]
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
+
+// UsageRecord is the usage of a single Chat GPT request.
+type UsageRecord struct {
+	ChatID     int64
+	WorkflowID string
+	Time       time.Time
+	Usage      TokenUsage
+}
+
+// UsageFilter selects usage records, zero values match any record.
+type UsageFilter struct {
+	ChatID int64
+	// WorkflowIDPrefix matches the session together with its follow-up turns.
+	WorkflowIDPrefix string
+	From, To         time.Time
+}
+
+// UsageStorage keeps usage totals per user, per day and per workflow.
+type UsageStorage interface {
+	Add(ctx context.Context, record UsageRecord) error
+	Get(ctx context.Context, filter UsageFilter) (TokenUsage, error)
+}
+
+// StartOfDay returns the beginning of the UTC day, usage is accounted in UTC days.
+func StartOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// StartOfMonth returns the beginning of the UTC month.
+func StartOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}